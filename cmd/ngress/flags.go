@@ -2,6 +2,7 @@ package main
 
 import (
 	"flag"
+	"ngress/internal/admin"
 	"ngress/internal/nginx"
 )
 
@@ -9,6 +10,7 @@ type flags struct {
 	kubeconfig *string
 	masterURL  *string
 	nginx      *nginx.Opts
+	admin      *admin.Opts
 }
 
 func newFlags() *flags {
@@ -16,5 +18,6 @@ func newFlags() *flags {
 		kubeconfig: flag.String("kubeconfig", "", "Path to a kubeconfig. Only required if out-of-cluster."),
 		masterURL:  flag.String("master", "", "The address of the Kubernetes API server. Overrides any value in kubeconfig. Only required if out-of-cluster."),
 		nginx:      nginx.NewOpts(),
+		admin:      admin.NewOpts(),
 	}
 }
@@ -4,6 +4,8 @@ import (
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/clientcmd"
 	"k8s.io/klog/v2"
+	"ngress/internal/admin"
+	"ngress/internal/metrics"
 	"ngress/internal/nginx"
 )
 
@@ -22,7 +24,10 @@ func main() {
 		klog.Fatalf("error building kubernetes clientset: %v", err)
 	}
 
-	configController := nginx.NewConfigController(f.nginx, kubeClient)
+	registry := metrics.NewRegistry()
+	configController := nginx.NewConfigController(f.nginx, kubeClient, registry)
+	adminServer := admin.NewServer(f.admin, buildInfoData(), configController, registry)
 	project.WaitStopSignal()
+	adminServer.Stop()
 	configController.Stop()
 }
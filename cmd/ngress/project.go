@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"github.com/petermattis/goid"
 	"k8s.io/klog/v2"
+	"ngress/internal/admin"
 	"os"
 	"os/signal"
 	"syscall"
@@ -19,6 +20,10 @@ func buildInfo() string {
 	return fmt.Sprintf("version: %v, build agent: '%v', build date: %v, hash: %v", Version, BuildAgent, BuildDate, Hash)
 }
 
+func buildInfoData() admin.BuildInfo {
+	return admin.BuildInfo{Version: Version, Hash: Hash, BuildDate: BuildDate, BuildAgent: BuildAgent}
+}
+
 type Project struct {
 	tag    string
 	chStop chan os.Signal
@@ -0,0 +1,15 @@
+package admin
+
+import "flag"
+
+type Opts struct {
+	address *string
+	pprof   *bool
+}
+
+func NewOpts() *Opts {
+	return &Opts{
+		address: flag.String("admin-address", ":10254", "admin http server address: /healthz, /readyz, /version, /metrics, empty - disabled"),
+		pprof:   flag.Bool("admin-pprof", false, "enable /debug/pprof/ handlers on the admin http server"),
+	}
+}
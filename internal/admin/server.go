@@ -0,0 +1,95 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"k8s.io/klog/v2"
+	"net/http"
+	"net/http/pprof"
+	"time"
+)
+
+const shutdownTimeout = 5 * time.Second
+
+type BuildInfo struct {
+	Version    string `json:"version"`
+	Hash       string `json:"hash"`
+	BuildDate  string `json:"buildDate"`
+	BuildAgent string `json:"buildAgent"`
+}
+
+// ReadinessChecker returns nil if the controller is ready to serve traffic
+type ReadinessChecker interface {
+	CheckReady() error
+}
+
+type Server struct {
+	server    *http.Server
+	info      BuildInfo
+	readiness ReadinessChecker
+}
+
+func NewServer(opts *Opts, info BuildInfo, readiness ReadinessChecker, metrics http.Handler) *Server {
+	c := &Server{info: info, readiness: readiness}
+	if len(*opts.address) == 0 {
+		klog.Infof("admin server disabled")
+		return c
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", c.healthz)
+	mux.HandleFunc("/readyz", c.readyz)
+	mux.HandleFunc("/version", c.version)
+	mux.Handle("/metrics", metrics)
+	if *opts.pprof {
+		mux.HandleFunc("/debug/pprof/", pprof.Index)
+		mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+		mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+		mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	}
+
+	c.server = &http.Server{Addr: *opts.address, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		klog.Infof("admin server listening on: %v, pprof: %v", *opts.address, *opts.pprof)
+		err := c.server.ListenAndServe()
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			klog.Fatalf("error: %v, admin server on: %v", err, *opts.address)
+		}
+	}()
+	return c
+}
+
+func (c *Server) Stop() {
+	if c.server == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	err := c.server.Shutdown(ctx)
+	if err != nil {
+		klog.Errorf("error: %v, admin server shutdown", err)
+	}
+}
+
+func (c *Server) healthz(w http.ResponseWriter, _ *http.Request) {
+	_, _ = w.Write([]byte("ok\n"))
+}
+
+func (c *Server) readyz(w http.ResponseWriter, _ *http.Request) {
+	err := c.readiness.CheckReady()
+	if err != nil {
+		http.Error(w, "not ready: "+err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	_, _ = w.Write([]byte("ok\n"))
+}
+
+func (c *Server) version(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(c.info)
+	if err != nil {
+		klog.Errorf("error: %v, writing version", err)
+	}
+}
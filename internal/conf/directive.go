@@ -0,0 +1,187 @@
+package conf
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// DollarVariable holds '$', nginx has no escape of '$' in strings interpolating variables
+const DollarVariable = "ngress_dollar"
+
+type argKind int
+
+const (
+	word     argKind = iota // token of the controller: keyword, number, variable, rendered as is
+	literal                 // quoted, '$' kept as is: values of not interpolating directives and own templates
+	value                   // quoted, '$' replaced by the dollar variable
+	template                // quoted, captures '$1'..'$9' kept, other '$' replaced by the dollar variable
+)
+
+type arg struct {
+	kind   argKind
+	s      string
+	suffix []string // words appended to the quoted value
+}
+
+// Directive is a simple or a block nginx directive, user supplied arguments are added by
+// Literal, Value and Template and always quoted, so they can not inject directives
+type Directive struct {
+	name     string
+	args     []arg
+	block    bool
+	children []*Directive
+}
+
+// New makes a simple directive with arguments of the controller
+func New(name string, words ...string) *Directive {
+	return (&Directive{name: name}).Words(words...)
+}
+
+// Block makes a block directive with arguments of the controller
+func Block(name string, words ...string) *Directive {
+	return (&Directive{name: name, block: true}).Words(words...)
+}
+
+func (c *Directive) Words(words ...string) *Directive {
+	for _, w := range words {
+		c.args = append(c.args, arg{kind: word, s: w})
+	}
+	return c
+}
+
+// Literal adds an argument not interpolated by nginx: location path, server name, regex
+func (c *Directive) Literal(s string) *Directive {
+	c.args = append(c.args, arg{kind: literal, s: s})
+	return c
+}
+
+// Value adds an argument interpolated by nginx, variables are not expanded in it,
+// words of the controller are appended as is: Value("http://"+host, "$request_uri")
+func (c *Directive) Value(s string, words ...string) *Directive {
+	c.args = append(c.args, arg{kind: value, s: s, suffix: words})
+	return c
+}
+
+// Template adds an argument interpolated by nginx, regex captures are expanded only
+func (c *Directive) Template(s string) *Directive {
+	c.args = append(c.args, arg{kind: template, s: s})
+	return c
+}
+
+// Add appends children of the block
+func (c *Directive) Add(children ...*Directive) *Directive {
+	for _, child := range children {
+		if child != nil {
+			c.children = append(c.children, child)
+		}
+	}
+	return c
+}
+
+// Dollar returns the declaration of the dollar variable, required by Value and Template arguments
+func Dollar() *Directive {
+	return Block("geo", "$"+DollarVariable).Add(New("default").Literal("$"))
+}
+
+// Check returns error if the value can not be an argument: control characters except tab
+func Check(s string) error {
+	for _, r := range s {
+		if (r < 0x20 && r != '\t') || r == 0x7f {
+			return errors.New(fmt.Sprintf("control character %q is not allowed", r))
+		}
+	}
+	return nil
+}
+
+// Render writes top level directives, a blank line separates blocks.
+// Nothing is written if any argument can not be rendered safely.
+func Render(sb *strings.Builder, directives ...*Directive) error {
+	var out strings.Builder
+	for _, d := range directives {
+		if d == nil {
+			continue
+		}
+		if d.block {
+			out.WriteString("\n")
+		}
+		if err := d.write(&out, 0); err != nil {
+			return err
+		}
+	}
+	sb.WriteString(out.String())
+	return nil
+}
+
+func (c *Directive) write(sb *strings.Builder, depth int) error {
+	if !isWord(c.name) {
+		return errors.New(fmt.Sprintf("invalid directive name %q", c.name))
+	}
+	sb.WriteString(strings.Repeat(" ", depth))
+	sb.WriteString(c.name)
+	for _, a := range c.args {
+		s, err := a.render()
+		if err != nil {
+			return errors.New(fmt.Sprintf("error: '%v' in %v argument %q", err, c.name, a.s))
+		}
+		sb.WriteString(" ")
+		sb.WriteString(s)
+	}
+	if !c.block {
+		sb.WriteString(";\n")
+		return nil
+	}
+	sb.WriteString(" {\n")
+	for _, child := range c.children {
+		if err := child.write(sb, depth+1); err != nil {
+			return err
+		}
+	}
+	sb.WriteString(strings.Repeat(" ", depth))
+	sb.WriteString("}\n")
+	return nil
+}
+
+func (c arg) render() (string, error) {
+	if c.kind == word {
+		if !isWord(c.s) {
+			return "", errors.New("not a plain word")
+		}
+		return c.s, nil
+	}
+	if err := Check(c.s); err != nil {
+		return "", err
+	}
+	s := strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(c.s)
+	switch c.kind {
+	case value:
+		s = strings.ReplaceAll(s, "$", "${"+DollarVariable+"}")
+	case template:
+		s = escapeDollar(s)
+	}
+	for _, w := range c.suffix {
+		if !isWord(w) {
+			return "", errors.New(fmt.Sprintf("suffix %q is not a plain word", w))
+		}
+		s += w
+	}
+	return `"` + s + `"`, nil
+}
+
+// escapeDollar replaces '$' not followed by a digit by the dollar variable
+func escapeDollar(s string) string {
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '$' && (i+1 == len(s) || s[i+1] < '1' || s[i+1] > '9') {
+			sb.WriteString("${" + DollarVariable + "}")
+			continue
+		}
+		sb.WriteByte(s[i])
+	}
+	return sb.String()
+}
+
+// isWord is true for a not empty token without whitespace, quotes, braces, ';', '#' and '\'
+func isWord(s string) bool {
+	return len(s) > 0 && !strings.ContainsAny(s, " \t\r\n;{}\"'#\\") && Check(s) == nil
+}
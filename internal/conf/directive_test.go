@@ -0,0 +1,131 @@
+package conf
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRenderArguments(t *testing.T) {
+	cases := []struct {
+		name      string
+		directive *Directive
+		expected  string // rendered text, empty - render error
+	}{
+		{"words", New("listen", "443", "ssl"), "listen 443 ssl;\n"},
+		{"variable word", New("set", "$ngress_path", "$host"), "set $ngress_path $host;\n"},
+		{"word with space", New("listen", "443 ssl"), ""},
+		{"word with semicolon", New("return", "444;"), ""},
+		{"word with brace", New("return", "}"), ""},
+		{"word with quote", New("return", `"444"`), ""},
+		{"word with backslash", New("return", `\`), ""},
+		{"word with comment", New("return", "#"), ""},
+		{"empty word", New("return", ""), ""},
+		{"invalid name", New("return 444;"), ""},
+
+		{"literal", New("server_name").Literal("example.com"), "server_name \"example.com\";\n"},
+		{"literal variable kept", New("server_name").Literal("$host"), "server_name \"$host\";\n"},
+		{"literal injection", New("server_name").Literal(`a"; } server { listen 80`),
+			"server_name \"a\\\"; } server { listen 80\";\n"},
+		{"literal trailing backslash", New("server_name").Literal(`a\`), "server_name \"a\\\\\";\n"},
+		{"literal backslash quote", New("server_name").Literal(`a\"`), "server_name \"a\\\\\\\"\";\n"},
+		{"literal tab", New("server_name").Literal("a\tb"), "server_name \"a\tb\";\n"},
+		{"literal newline", New("server_name").Literal("a\n}"), ""},
+		{"literal carriage return", New("server_name").Literal("a\r"), ""},
+		{"literal nul", New("server_name").Literal("a\x00"), ""},
+		{"literal del", New("server_name").Literal("a\x7f"), ""},
+		{"empty literal", New("default").Literal(""), "default \"\";\n"},
+
+		{"value", New("proxy_pass").Value("http://svc.ns:80"), "proxy_pass \"http://svc.ns:80\";\n"},
+		{"value variable escaped", New("add_header", "X").Value("$host"),
+			"add_header X \"${ngress_dollar}host\";\n"},
+		{"value capture escaped", New("add_header", "X").Value("$1"), "add_header X \"${ngress_dollar}1\";\n"},
+		{"value injection", New("add_header", "X").Value(`"; } server { return 200 "$host`),
+			"add_header X \"\\\"; } server { return 200 \\\"${ngress_dollar}host\";\n"},
+		{"value suffix", New("return", "308").Value("https://$a.com", "$request_uri"),
+			"return 308 \"https://${ngress_dollar}a.com$request_uri\";\n"},
+		{"value invalid suffix", New("return", "308").Value("https://a.com", "$request_uri;"), ""},
+		{"value newline", New("proxy_pass").Value("http://a\n"), ""},
+
+		{"template captures kept", New("rewrite").Literal("^/a/(.*)$").Template("/$1/$9").Words("break"),
+			"rewrite \"^/a/(.*)$\" \"/$1/$9\" break;\n"},
+		{"template variable escaped", New("rewrite").Literal("^(.*)$").Template("/$1$host"),
+			"rewrite \"^(.*)$\" \"/$1${ngress_dollar}host\";\n"},
+		{"template $0 escaped", New("rewrite").Literal("^(.*)$").Template("/$0"),
+			"rewrite \"^(.*)$\" \"/${ngress_dollar}0\";\n"},
+		{"template trailing dollar", New("rewrite").Literal("^(.*)$").Template("/$"),
+			"rewrite \"^(.*)$\" \"/${ngress_dollar}\";\n"},
+		{"template nul", New("rewrite").Literal("^(.*)$").Template("/$1\x00"), ""},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var sb strings.Builder
+			err := Render(&sb, c.directive)
+			if len(c.expected) == 0 {
+				if err == nil {
+					t.Errorf("rendered %q, expected error", sb.String())
+				}
+				if sb.Len() > 0 {
+					t.Errorf("rendered %q on error", sb.String())
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("error: %v", err)
+			}
+			if sb.String() != c.expected {
+				t.Errorf("rendered %q, expected %q", sb.String(), c.expected)
+			}
+		})
+	}
+}
+
+func TestRenderBlocks(t *testing.T) {
+	var sb strings.Builder
+	err := Render(&sb, Dollar(), Block("server").Add(
+		New("listen", "80"),
+		nil,
+		Block("location", "=").Literal("/a").Add(New("return", "444"))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `
+geo $ngress_dollar {
+ default "$";
+}
+
+server {
+ listen 80;
+ location = "/a" {
+  return 444;
+ }
+}
+`
+	if sb.String() != expected {
+		t.Errorf("rendered %q, expected %q", sb.String(), expected)
+	}
+}
+
+// nothing is written if a nested argument can not be rendered
+func TestRenderNestedError(t *testing.T) {
+	sb := strings.Builder{}
+	sb.WriteString("# head\n")
+	err := Render(&sb, New("listen", "80"), Block("server").Add(
+		Block("location").Literal("/a\n} server {")))
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if sb.String() != "# head\n" {
+		t.Errorf("rendered %q on error", sb.String())
+	}
+}
+
+func TestCheck(t *testing.T) {
+	for s, valid := range map[string]bool{
+		"/foo": true, "a\tb": true, "ünïcode": true, `"; }`: true,
+		"a\nb": false, "a\rb": false, "\x00": false, "\x1b[0m": false, "\x7f": false,
+	} {
+		if err := Check(s); (err == nil) != valid {
+			t.Errorf("Check(%q): %v, expected valid: %v", s, err, valid)
+		}
+	}
+}
@@ -0,0 +1,218 @@
+package dns
+
+import (
+	"errors"
+	"fmt"
+	"golang.org/x/net/dns/dnsmessage"
+	"k8s.io/klog/v2"
+	"net"
+	"strings"
+	"sync"
+)
+
+const (
+	Zone           = "ngress.local."
+	TTL            = 5 // seconds, nginx resolver caches answers for it
+	maxMessageSize = 512
+	maxResponse    = 4096 // nginx resolver reads UDP answers up to 4k
+	maxAnswers     = 64
+)
+
+// Records are ready endpoints of a service port
+type Records struct {
+	IPs  []net.IP
+	Port uint16
+}
+
+// Server is a DNS responder answering A, AAAA and SRV queries of the names in Zone by the current records.
+// SRV targets are named '<ip with dashes>.<name>', their A/AAAA queries are answered too.
+type Server struct {
+	address string
+	conn    net.PacketConn
+	wg      sync.WaitGroup
+	mu      sync.RWMutex // guards records
+	records map[string]*Records
+}
+
+// NewServer listens UDP on the loopback address 'ip:port'
+func NewServer(address string) (*Server, error) {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("error: '%v' parsing dns address: %v", err, address))
+	}
+	if ip := net.ParseIP(host); ip == nil || !ip.IsLoopback() {
+		return nil, errors.New(fmt.Sprintf("error: dns address %v is not a loopback ip", address))
+	}
+
+	c := &Server{address: address, records: make(map[string]*Records)}
+	c.conn, err = net.ListenPacket("udp", address)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("error: '%v' listen dns on: %v", err, address))
+	}
+
+	c.wg.Add(1)
+	go c.run()
+	klog.Infof("dns server listening on: %v, zone: %v", address, Zone)
+	return c, nil
+}
+
+func (c *Server) Address() string {
+	return c.address
+}
+
+// Update replaces all the records: fully qualified lowercase name -> records
+func (c *Server) Update(records map[string]*Records) {
+	c.mu.Lock()
+	c.records = records
+	c.mu.Unlock()
+}
+
+func (c *Server) Stop() {
+	_ = c.conn.Close()
+	c.wg.Wait()
+}
+
+func (c *Server) run() {
+	defer c.wg.Done()
+	buf := make([]byte, maxMessageSize)
+	for {
+		n, addr, err := c.conn.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			klog.Warningf("dns server read error: %v", err)
+			continue
+		}
+		response, err := c.handle(buf[:n])
+		if err != nil {
+			klog.V(2).Infof("dns query from %v dropped: %v", addr, err)
+			continue
+		}
+		_, err = c.conn.WriteTo(response, addr)
+		if err != nil {
+			klog.Warningf("dns server write error: %v", err)
+		}
+	}
+}
+
+func (c *Server) handle(query []byte) ([]byte, error) {
+	var parser dnsmessage.Parser
+	header, err := parser.Start(query)
+	if err != nil {
+		return nil, err
+	}
+	if header.Response {
+		return nil, errors.New("not a query")
+	}
+	question, err := parser.Question()
+	if err != nil {
+		return nil, err
+	}
+
+	response := dnsmessage.Header{ID: header.ID, Response: true, OpCode: header.OpCode, RecursionDesired: header.RecursionDesired}
+	name := strings.ToLower(question.Name.String())
+	if header.OpCode != 0 || question.Class != dnsmessage.ClassINET {
+		response.RCode = dnsmessage.RCodeNotImplemented
+		return c.build(response, question, nil)
+	}
+	if !strings.HasSuffix(name, "."+Zone) {
+		response.RCode = dnsmessage.RCodeRefused
+		return c.build(response, question, nil)
+	}
+	response.Authoritative = true
+
+	c.mu.RLock()
+	records, ok := c.records[name]
+	var target net.IP
+	if !ok {
+		// SRV target name
+		if label, parent, found := strings.Cut(name, "."); found {
+			if r, parentOk := c.records[parent]; parentOk {
+				target = targetIP(label, r)
+			}
+		}
+	}
+	c.mu.RUnlock()
+
+	if !ok && target == nil {
+		response.RCode = dnsmessage.RCodeNameError
+		return c.build(response, question, nil)
+	}
+	if target != nil {
+		records = &Records{IPs: []net.IP{target}}
+	}
+	return c.build(response, question, records)
+}
+
+// targetIP returns the ip of the SRV target label if it belongs to the records
+func targetIP(label string, records *Records) net.IP {
+	for _, ip := range records.IPs {
+		if targetLabel(ip) == label {
+			return ip
+		}
+	}
+	return nil
+}
+
+func targetLabel(ip net.IP) string {
+	return strings.NewReplacer(".", "-", ":", "-").Replace(ip.String())
+}
+
+func (c *Server) build(header dnsmessage.Header, question dnsmessage.Question, records *Records) ([]byte, error) {
+	builder := dnsmessage.NewBuilder(make([]byte, 0, maxMessageSize), header)
+	builder.EnableCompression()
+	if err := builder.StartQuestions(); err != nil {
+		return nil, err
+	}
+	if err := builder.Question(question); err != nil {
+		return nil, err
+	}
+	if err := builder.StartAnswers(); err != nil {
+		return nil, err
+	}
+
+	if records != nil {
+		name := question.Name
+		rh := dnsmessage.ResourceHeader{Name: name, Class: dnsmessage.ClassINET, TTL: TTL}
+		answers := 0
+		for _, ip := range records.IPs {
+			if answers == maxAnswers {
+				break
+			}
+			var err error
+			ip4 := ip.To4()
+			switch {
+			case question.Type == dnsmessage.TypeA && ip4 != nil:
+				r := dnsmessage.AResource{}
+				copy(r.A[:], ip4)
+				err = builder.AResource(rh, r)
+			case question.Type == dnsmessage.TypeAAAA && ip4 == nil:
+				r := dnsmessage.AAAAResource{}
+				copy(r.AAAA[:], ip.To16())
+				err = builder.AAAAResource(rh, r)
+			case question.Type == dnsmessage.TypeSRV && records.Port != 0:
+				var target dnsmessage.Name
+				target, err = dnsmessage.NewName(targetLabel(ip) + "." + name.String())
+				if err == nil {
+					err = builder.SRVResource(rh, dnsmessage.SRVResource{Weight: 1, Port: records.Port, Target: target})
+				}
+			default:
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			answers++
+		}
+	}
+
+	msg, err := builder.Finish()
+	if err != nil {
+		return nil, err
+	}
+	if len(msg) > maxResponse {
+		return nil, errors.New(fmt.Sprintf("response of %v bytes is too large", len(msg)))
+	}
+	return msg, nil
+}
@@ -0,0 +1,194 @@
+package dns
+
+import (
+	"fmt"
+	"golang.org/x/net/dns/dnsmessage"
+	"net"
+	"testing"
+	"time"
+)
+
+const testName = "80.svc.ns." + Zone
+
+func testServer(t *testing.T) *Server {
+	c, err := NewServer("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(c.Stop)
+	c.Update(map[string]*Records{testName: {Port: 8080,
+		IPs: []net.IP{net.ParseIP("10.0.0.1"), net.ParseIP("10.0.0.2"), net.ParseIP("fd00::1")}}})
+	return c
+}
+
+func testQuery(name string, qtype dnsmessage.Type, class dnsmessage.Class) []byte {
+	msg := dnsmessage.Message{Header: dnsmessage.Header{ID: 42, RecursionDesired: true},
+		Questions: []dnsmessage.Question{{Name: dnsmessage.MustNewName(name), Type: qtype, Class: class}}}
+	query, err := msg.Pack()
+	if err != nil {
+		panic(err)
+	}
+	return query
+}
+
+// exchange sends the query to the server over UDP and returns the parsed response
+func exchange(t *testing.T, c *Server, query []byte) *dnsmessage.Message {
+	conn, err := net.Dial("udp", c.conn.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if err = conn.SetDeadline(time.Now().Add(2 * time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = conn.Write(query); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, maxResponse)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var response dnsmessage.Message
+	if err = response.Unpack(buf[:n]); err != nil {
+		t.Fatal(err)
+	}
+	if response.ID != 42 || !response.Response {
+		t.Errorf("response header %+v", response.Header)
+	}
+	return &response
+}
+
+// answers returns answers of the response as strings: ip for A/AAAA, 'target:port' for SRV
+func answers(response *dnsmessage.Message) []string {
+	var out []string
+	for _, answer := range response.Answers {
+		switch r := answer.Body.(type) {
+		case *dnsmessage.AResource:
+			out = append(out, net.IP(r.A[:]).String())
+		case *dnsmessage.AAAAResource:
+			out = append(out, net.IP(r.AAAA[:]).String())
+		case *dnsmessage.SRVResource:
+			out = append(out, fmt.Sprintf("%v:%v", r.Target.String(), r.Port))
+		}
+	}
+	return out
+}
+
+func TestQueries(t *testing.T) {
+	c := testServer(t)
+	cases := []struct {
+		name    string
+		qname   string
+		qtype   dnsmessage.Type
+		class   dnsmessage.Class
+		rcode   dnsmessage.RCode
+		answers []string
+	}{
+		{"A", testName, dnsmessage.TypeA, dnsmessage.ClassINET, dnsmessage.RCodeSuccess,
+			[]string{"10.0.0.1", "10.0.0.2"}},
+		{"A of upper case name", "80.SVC.ns." + Zone, dnsmessage.TypeA, dnsmessage.ClassINET, dnsmessage.RCodeSuccess,
+			[]string{"10.0.0.1", "10.0.0.2"}},
+		{"AAAA", testName, dnsmessage.TypeAAAA, dnsmessage.ClassINET, dnsmessage.RCodeSuccess,
+			[]string{"fd00::1"}},
+		{"SRV", testName, dnsmessage.TypeSRV, dnsmessage.ClassINET, dnsmessage.RCodeSuccess,
+			[]string{"10-0-0-1." + testName + ":8080", "10-0-0-2." + testName + ":8080", "fd00--1." + testName + ":8080"}},
+		{"A of SRV target", "10-0-0-2." + testName, dnsmessage.TypeA, dnsmessage.ClassINET, dnsmessage.RCodeSuccess,
+			[]string{"10.0.0.2"}},
+		{"AAAA of SRV target", "fd00--1." + testName, dnsmessage.TypeAAAA, dnsmessage.ClassINET, dnsmessage.RCodeSuccess,
+			[]string{"fd00::1"}},
+		{"AAAA of IPv4 SRV target", "10-0-0-2." + testName, dnsmessage.TypeAAAA, dnsmessage.ClassINET,
+			dnsmessage.RCodeSuccess, nil},
+		{"unknown SRV target", "10-0-0-3." + testName, dnsmessage.TypeA, dnsmessage.ClassINET,
+			dnsmessage.RCodeNameError, nil},
+		{"TXT", testName, dnsmessage.TypeTXT, dnsmessage.ClassINET, dnsmessage.RCodeSuccess, nil},
+		{"unknown name", "81.svc.ns." + Zone, dnsmessage.TypeA, dnsmessage.ClassINET, dnsmessage.RCodeNameError, nil},
+		{"out of zone", "example.com.", dnsmessage.TypeA, dnsmessage.ClassINET, dnsmessage.RCodeRefused, nil},
+		{"zone itself", Zone, dnsmessage.TypeA, dnsmessage.ClassINET, dnsmessage.RCodeRefused, nil},
+		{"not INET class", testName, dnsmessage.TypeA, dnsmessage.ClassCHAOS, dnsmessage.RCodeNotImplemented, nil},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			response := exchange(t, c, testQuery(tc.qname, tc.qtype, tc.class))
+			if response.RCode != tc.rcode {
+				t.Errorf("rcode %v, expected %v", response.RCode, tc.rcode)
+			}
+			if len(response.Questions) != 1 || response.Questions[0].Name.String() != tc.qname {
+				t.Errorf("questions %+v", response.Questions)
+			}
+			got := answers(response)
+			if len(got) != len(tc.answers) {
+				t.Fatalf("answers %v, expected %v", got, tc.answers)
+			}
+			for i := range got {
+				if got[i] != tc.answers[i] {
+					t.Errorf("answers %v, expected %v", got, tc.answers)
+				}
+			}
+			for _, answer := range response.Answers {
+				if answer.Header.TTL != TTL {
+					t.Errorf("TTL %v, expected %v", answer.Header.TTL, TTL)
+				}
+			}
+		})
+	}
+}
+
+func TestMalformedQueries(t *testing.T) {
+	c := testServer(t)
+	query := testQuery(testName, dnsmessage.TypeA, dnsmessage.ClassINET)
+	response, err := (&dnsmessage.Message{Header: dnsmessage.Header{ID: 42, Response: true}}).Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	noQuestion, err := (&dnsmessage.Message{Header: dnsmessage.Header{ID: 42}}).Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, packet := range map[string][]byte{
+		"empty":              {},
+		"truncated header":   query[:5],
+		"truncated question": query[:len(query)-3],
+		"response":           response,
+		"no question":        noQuestion,
+		"garbage":            []byte("\x00\x2a\x01\x00\x00\x01\x00\x00\x00\x00\x00\x00\xff\xff\xff"),
+	} {
+		if _, err := c.handle(packet); err == nil {
+			t.Errorf("%v: no error", name)
+		}
+	}
+
+	// malformed packets are dropped, the server answers the next query
+	conn, err := net.Dial("udp", c.conn.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err = conn.Write(query[:5]); err != nil {
+		t.Fatal(err)
+	}
+	if got := answers(exchange(t, c, query)); len(got) != 2 {
+		t.Errorf("answers %v after malformed query", got)
+	}
+}
+
+func TestMaxAnswers(t *testing.T) {
+	c := testServer(t)
+	records := &Records{Port: 80}
+	for i := 0; i < maxAnswers+10; i++ {
+		records.IPs = append(records.IPs, net.IPv4(10, 1, byte(i/256), byte(i%256)))
+	}
+	c.Update(map[string]*Records{testName: records})
+	if got := answers(exchange(t, c, testQuery(testName, dnsmessage.TypeA, dnsmessage.ClassINET))); len(got) != maxAnswers {
+		t.Errorf("%v answers, expected %v", len(got), maxAnswers)
+	}
+}
+
+func TestLoopbackOnly(t *testing.T) {
+	for _, address := range []string{"0.0.0.0:0", "10.0.0.1:53", "localhost:53", "127.0.0.1"} {
+		if c, err := NewServer(address); err == nil {
+			c.Stop()
+			t.Errorf("%v accepted", address)
+		}
+	}
+}
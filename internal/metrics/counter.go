@@ -0,0 +1,85 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+)
+
+type CounterVec struct {
+	vec[float64]
+}
+
+// NewCounterVec registers monotonically increasing counter, name should end with _total
+func (c *Registry) NewCounterVec(name string, help string, labels ...string) *CounterVec {
+	m := &CounterVec{vec: newVec[float64](name, help, "counter", labels)}
+	c.register(m)
+	return m
+}
+
+func (c *CounterVec) Inc(labelValues ...string) error {
+	return c.Add(1, labelValues...)
+}
+
+func (c *CounterVec) Add(v float64, labelValues ...string) error {
+	if v < 0 {
+		return nil // counters can't decrease
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	value, err := c.with(labelValues)
+	if err != nil {
+		return err
+	}
+	*value += v
+	return nil
+}
+
+func (c *CounterVec) write(w *bufio.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.writeHeader(w)
+	for _, key := range c.sortedKeys() {
+		_, _ = fmt.Fprintf(w, "%s%s %s\n", c.name, c.labelPairs(key, ""), formatFloat(*c.values[key]))
+	}
+}
+
+type GaugeVec struct {
+	vec[float64]
+}
+
+func (c *Registry) NewGaugeVec(name string, help string, labels ...string) *GaugeVec {
+	m := &GaugeVec{vec: newVec[float64](name, help, "gauge", labels)}
+	c.register(m)
+	return m
+}
+
+func (c *GaugeVec) Set(v float64, labelValues ...string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	value, err := c.with(labelValues)
+	if err != nil {
+		return err
+	}
+	*value = v
+	return nil
+}
+
+func (c *GaugeVec) Add(v float64, labelValues ...string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	value, err := c.with(labelValues)
+	if err != nil {
+		return err
+	}
+	*value += v
+	return nil
+}
+
+func (c *GaugeVec) write(w *bufio.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.writeHeader(w)
+	for _, key := range c.sortedKeys() {
+		_, _ = fmt.Fprintf(w, "%s%s %s\n", c.name, c.labelPairs(key, ""), formatFloat(*c.values[key]))
+	}
+}
@@ -0,0 +1,68 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// DefaultBuckets are latency buckets in seconds
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60}
+
+type histogramValue struct {
+	counts []uint64 // per bucket, not cumulative
+	count  uint64
+	sum    float64
+}
+
+type HistogramVec struct {
+	vec[histogramValue]
+	buckets []float64
+}
+
+func (c *Registry) NewHistogramVec(name string, help string, buckets []float64, labels ...string) *HistogramVec {
+	b := append([]float64(nil), buckets...)
+	sort.Float64s(b)
+	m := &HistogramVec{vec: newVec[histogramValue](name, help, "histogram", labels), buckets: b}
+	c.register(m)
+	return m
+}
+
+func (c *HistogramVec) Observe(v float64, labelValues ...string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	h, err := c.with(labelValues)
+	if err != nil {
+		return err
+	}
+	if h.counts == nil {
+		h.counts = make([]uint64, len(c.buckets))
+	}
+	i := sort.SearchFloat64s(c.buckets, v) // first bucket with upper bound >= v
+	if i < len(c.buckets) {
+		h.counts[i]++
+	}
+	h.count++
+	h.sum += v
+	return nil
+}
+
+func (c *HistogramVec) write(w *bufio.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.writeHeader(w)
+	for _, key := range c.sortedKeys() {
+		h := c.values[key]
+		var cumulative uint64
+		for i, upper := range c.buckets {
+			cumulative += h.counts[i]
+			le := fmt.Sprintf("le=\"%s\"", formatFloat(upper))
+			_, _ = fmt.Fprintf(w, "%s_bucket%s %d\n", c.name, c.labelPairs(key, le), cumulative)
+		}
+		le := fmt.Sprintf("le=\"%s\"", formatFloat(math.Inf(1)))
+		_, _ = fmt.Fprintf(w, "%s_bucket%s %d\n", c.name, c.labelPairs(key, le), h.count)
+		_, _ = fmt.Fprintf(w, "%s_sum%s %s\n", c.name, c.labelPairs(key, ""), formatFloat(h.sum))
+		_, _ = fmt.Fprintf(w, "%s_count%s %d\n", c.name, c.labelPairs(key, ""), h.count)
+	}
+}
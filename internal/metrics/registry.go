@@ -0,0 +1,47 @@
+package metrics
+
+import (
+	"bufio"
+	"k8s.io/klog/v2"
+	"net/http"
+	"sync"
+)
+
+// collector writes metric family in the prometheus text exposition format
+type collector interface {
+	write(w *bufio.Writer)
+}
+
+// Registry is a minimal prometheus compatible metrics registry
+type Registry struct {
+	mu         sync.Mutex
+	collectors []collector
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (c *Registry) register(m collector) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.collectors = append(c.collectors, m)
+}
+
+// ServeHTTP writes all registered metrics in the prometheus text format
+func (c *Registry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	c.mu.Lock()
+	collectors := c.collectors
+	c.mu.Unlock()
+
+	bw := bufio.NewWriter(w)
+	for _, m := range collectors {
+		m.write(bw)
+	}
+	err := bw.Flush()
+	if err != nil {
+		klog.Errorf("error: %v, writing metrics", err)
+	}
+}
@@ -0,0 +1,116 @@
+package metrics
+
+import (
+	"math"
+	"net/http/httptest"
+	"testing"
+)
+
+func render(registry *Registry) string {
+	recorder := httptest.NewRecorder()
+	registry.ServeHTTP(recorder, nil)
+	if contentType := recorder.Header().Get("Content-Type"); contentType != "text/plain; version=0.0.4; charset=utf-8" {
+		return "content type: " + contentType
+	}
+	return recorder.Body.String()
+}
+
+func TestExposition(t *testing.T) {
+	registry := NewRegistry()
+	requests := registry.NewCounterVec("test_requests_total", "Requests\\by \"status\"\nclass.", "code", "path")
+	ready := registry.NewGaugeVec("test_ready", "Ready.")
+	duration := registry.NewHistogramVec("test_duration_seconds", "Duration.", []float64{1, 0.5}, "path")
+	registry.NewCounterVec("test_empty_total", "Empty.", "code")
+
+	_ = requests.Inc("200", "/b")
+	_ = requests.Add(2, "200", "/a")
+	_ = requests.Add(-1, "200", "/a") // counters never decrease
+	_ = requests.Inc("500", "/\"quoted\"\\back\nslash")
+	_ = ready.Set(1)
+	_ = ready.Add(0.5)
+	_ = duration.Observe(0.5, "/a")
+	_ = duration.Observe(0.7, "/a")
+	_ = duration.Observe(3, "/a")
+
+	expected := `# HELP test_requests_total Requests\\by "status"\nclass.
+# TYPE test_requests_total counter
+test_requests_total{code="200",path="/a"} 2
+test_requests_total{code="200",path="/b"} 1
+test_requests_total{code="500",path="/\"quoted\"\\back\nslash"} 1
+# HELP test_ready Ready.
+# TYPE test_ready gauge
+test_ready 1.5
+# HELP test_duration_seconds Duration.
+# TYPE test_duration_seconds histogram
+test_duration_seconds_bucket{path="/a",le="0.5"} 1
+test_duration_seconds_bucket{path="/a",le="1"} 2
+test_duration_seconds_bucket{path="/a",le="+Inf"} 3
+test_duration_seconds_sum{path="/a"} 4.2
+test_duration_seconds_count{path="/a"} 3
+# HELP test_empty_total Empty.
+# TYPE test_empty_total counter
+`
+	if out := render(registry); out != expected {
+		t.Errorf("exposition:\n%v\nexpected:\n%v", out, expected)
+	}
+}
+
+func TestLabelCountMismatch(t *testing.T) {
+	registry := NewRegistry()
+	requests := registry.NewCounterVec("test_requests_total", "Requests.", "code")
+	ready := registry.NewGaugeVec("test_ready", "Ready.")
+	duration := registry.NewHistogramVec("test_duration_seconds", "Duration.", []float64{1}, "path")
+
+	for name, err := range map[string]error{
+		"counter":   requests.Inc("200", "/a"),
+		"gauge":     ready.Set(1, "x"),
+		"histogram": duration.Observe(1),
+	} {
+		if err == nil {
+			t.Errorf("%v: no error", name)
+		}
+	}
+	expected := `# HELP test_requests_total Requests.
+# TYPE test_requests_total counter
+# HELP test_ready Ready.
+# TYPE test_ready gauge
+# HELP test_duration_seconds Duration.
+# TYPE test_duration_seconds histogram
+`
+	if out := render(registry); out != expected {
+		t.Errorf("exposition:\n%v\nexpected:\n%v", out, expected)
+	}
+}
+
+func TestResetAndDelete(t *testing.T) {
+	registry := NewRegistry()
+	requests := registry.NewCounterVec("test_requests_total", "Requests.", "code")
+	hash := registry.NewGaugeVec("test_hash", "Hash.", "hash")
+	_ = requests.Inc("200")
+	_ = requests.Inc("500")
+	_ = hash.Set(1, "a")
+
+	requests.DeleteFunc(func(labelValues []string) bool { return labelValues[0] == "500" })
+	hash.Reset()
+	_ = hash.Set(1, "b")
+
+	expected := `# HELP test_requests_total Requests.
+# TYPE test_requests_total counter
+test_requests_total{code="200"} 1
+# HELP test_hash Hash.
+# TYPE test_hash gauge
+test_hash{hash="b"} 1
+`
+	if out := render(registry); out != expected {
+		t.Errorf("exposition:\n%v\nexpected:\n%v", out, expected)
+	}
+}
+
+func TestFormatFloat(t *testing.T) {
+	for v, expected := range map[float64]string{0: "0", 1.5: "1.5", 1e-9: "1e-09", 123456789: "1.23456789e+08",
+		math.Inf(1): "+Inf", math.Inf(-1): "-Inf"} {
+		if s := formatFloat(v); s != expected {
+			t.Errorf("formatFloat(%v): %v, expected %v", v, s, expected)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package metrics
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const labelSeparator = "\xff"
+
+// vec keeps metric values by label values, label values are joined to the key
+type vec[Value any] struct {
+	mu     sync.Mutex
+	name   string
+	help   string
+	kind   string
+	labels []string
+	values map[string]*Value
+}
+
+func newVec[Value any](name string, help string, kind string, labels []string) vec[Value] {
+	return vec[Value]{name: name, help: help, kind: kind, labels: labels, values: make(map[string]*Value)}
+}
+
+// with returns value for the label values, error if their number does not match labels, must be called under mu
+func (c *vec[Value]) with(labelValues []string) (*Value, error) {
+	if len(labelValues) != len(c.labels) {
+		return nil, errors.New(fmt.Sprintf("error: metric %v expected %d label values, got %d",
+			c.name, len(c.labels), len(labelValues)))
+	}
+	key := strings.Join(labelValues, labelSeparator)
+	v, ok := c.values[key]
+	if !ok {
+		v = new(Value)
+		c.values[key] = v
+	}
+	return v, nil
+}
+
+// Reset removes all values, used for metrics describing the current state
+func (c *vec[Value]) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.values = make(map[string]*Value)
+}
+
+// DeleteFunc removes values of the label values matching del, used for series of removed objects
+func (c *vec[Value]) DeleteFunc(del func(labelValues []string) bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key := range c.values {
+		if del(strings.Split(key, labelSeparator)) {
+			delete(c.values, key)
+		}
+	}
+}
+
+func (c *vec[Value]) writeHeader(w *bufio.Writer) {
+	_, _ = fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", c.name, escapeHelp(c.help), c.name, c.kind)
+}
+
+// sortedKeys returns keys sorted for stable output, must be called under mu
+func (c *vec[Value]) sortedKeys() []string {
+	keys := make([]string, 0, len(c.values))
+	for key := range c.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// labelPairs formats {name="value",...}, extra label pair is appended if not empty
+func (c *vec[Value]) labelPairs(key string, extra string) string {
+	pairs := make([]string, 0, len(c.labels)+1)
+	if len(c.labels) > 0 {
+		for i, value := range strings.Split(key, labelSeparator) {
+			pairs = append(pairs, fmt.Sprintf("%s=\"%s\"", c.labels[i], escapeLabelValue(value)))
+		}
+	}
+	if len(extra) > 0 {
+		pairs = append(pairs, extra)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func escapeHelp(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(s)
+}
+
+func escapeLabelValue(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`).Replace(s)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,108 @@
+package nginx
+
+import (
+	"fmt"
+	networking "k8s.io/api/networking/v1"
+	"k8s.io/klog/v2"
+	"ngress/internal/conf"
+	"ngress/internal/utils"
+	"strings"
+)
+
+// wwwPair returns the other form of the host for from-to-www redirect: 'www.foo.com' <-> 'foo.com'
+func wwwPair(host string) string {
+	if apex, ok := strings.CutPrefix(host, "www."); ok {
+		return apex
+	}
+	return "www." + host
+}
+
+// resolveAliases sets aliases and the from-to-www redirect source of the host from its annotations,
+// names served by hosts or claimed by aliases of other hosts are skipped
+func (c *Host) resolveAliases(hosts map[string]*Host, claimed map[string]string) {
+	c.aliases = nil
+	c.redirectFrom = ""
+	if len(c.host) == 0 || isWildcard(c.host) {
+		return
+	}
+
+	claim := func(name string) bool {
+		if _, ok := hosts[name]; ok {
+			klog.Warningf("%v> %v skipped, served by the host", c.tag, name)
+			return false
+		}
+		if owner, ok := claimed[name]; ok {
+			if owner != c.host {
+				klog.Warningf("%v> %v skipped, already an alias of HOST:%v", c.tag, name, owner)
+			}
+			return false
+		}
+		claimed[name] = c.host
+		return true
+	}
+
+	for _, alias := range strings.Split(c.annotations.aliases, ",") {
+		alias = strings.ToLower(strings.TrimSpace(alias))
+		if len(alias) == 0 || isWildcard(alias) {
+			continue
+		}
+		if claim(alias) {
+			c.aliases = append(c.aliases, alias)
+		}
+	}
+
+	if c.annotations.fromToWWW {
+		if from := wwwPair(c.host); claim(from) {
+			c.redirectFrom = from
+		}
+	}
+}
+
+// checkAliasesTLS reports aliases and the redirect source not covered by the certificate of the host,
+// returns true if the redirect source is covered
+func (c *Host) checkAliasesTLS(secret *Secret) bool {
+	owner := c.annotationsOwner()
+	for _, alias := range c.aliases {
+		if !secret.covers(alias) {
+			c.skipped = append(c.skipped, &Problem{ingress: owner, reason: reasonTLSSecretMismatch,
+				message: fmt.Sprintf("certificate of TLS secret %v does not cover alias %v of host %v",
+					secret.name(), alias, c.host)})
+		}
+	}
+	if len(c.redirectFrom) == 0 || secret.covers(c.redirectFrom) {
+		return true
+	}
+	klog.Warningf("%v> https redirect from %v skipped, not covered by <SECRET:%v>", c.tag, c.redirectFrom, secret.name())
+	return false
+}
+
+// annotationsOwner returns the ingress of the first sorted route of the host for events of host annotations
+func (c *Host) annotationsOwner() *networking.Ingress {
+	for _, key := range utils.SortedKeys(c.routes) {
+		return c.routes[key].owner
+	}
+	return nil
+}
+
+// redirectServers returns servers redirecting from the other www form to the host,
+// scheme, port and request URI are kept, https one only if the certificate covers the source
+func (c *Server) redirectServers(https bool) []*conf.Directive {
+	port := func(port uint16, standard uint16) string {
+		if port == standard {
+			return ""
+		}
+		return fmt.Sprintf(":%v", port)
+	}
+
+	servers := []*conf.Directive{conf.Block("server").Add(c.listen(c.unsecurePort)...).Add(
+		conf.New("server_name").Literal(c.redirectFrom),
+		conf.New("return", "308").Value("http://"+c.name+port(c.unsecurePort, defaultUnsecurePort), "$request_uri"))}
+	if !https {
+		return servers
+	}
+	return append(servers, conf.Block("server").Add(c.listen(c.securePort, "ssl")...).Add(
+		conf.New("server_name").Literal(c.redirectFrom),
+		conf.New("ssl_certificate").Value(c.sslCertPath),
+		conf.New("ssl_certificate_key").Value(c.sslCertKeyPath),
+		conf.New("return", "308").Value("https://"+c.name+port(c.securePort, defaultSecurePort), "$request_uri")))
+}
@@ -0,0 +1,134 @@
+package nginx
+
+import (
+	core "k8s.io/api/core/v1"
+	networking "k8s.io/api/networking/v1"
+	"ngress/internal/conf"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestResolveAliases(t *testing.T) {
+	host := func(name string, annotations map[string]string) *Host {
+		c := newHost(&networking.IngressRule{Host: name}, nil, newServices(nil))
+		c.applyAnnotations(newAnnotations(annotations))
+		return c
+	}
+	hosts := map[string]*Host{
+		"a.com": host("a.com", map[string]string{"ngress.server/aliases": "B.com, c.com,,*.x.com,d.com",
+			"ngress.server/from-to-www-redirect": "true"}),
+		"d.com":     host("d.com", nil),
+		"e.com":     host("e.com", map[string]string{"ngress.server/aliases": "c.com,f.com"}),
+		"www.g.com": host("www.g.com", map[string]string{"ngress.server/from-to-www-redirect": "true"}),
+		"g.com":     host("g.com", nil),
+	}
+	claimed := make(map[string]string)
+	for _, name := range []string{"a.com", "d.com", "e.com", "g.com", "www.g.com"} {
+		hosts[name].resolveAliases(hosts, claimed)
+	}
+
+	cases := []struct {
+		host         string
+		aliases      []string
+		redirectFrom string
+	}{
+		{"a.com", []string{"b.com", "c.com"}, "www.a.com"},
+		{"e.com", []string{"f.com"}, ""},
+		{"www.g.com", nil, ""}, // g.com is served by its own host
+	}
+	for _, c := range cases {
+		h := hosts[c.host]
+		if !reflect.DeepEqual(h.aliases, c.aliases) || h.redirectFrom != c.redirectFrom {
+			t.Errorf("%v: aliases %v redirect from %q, expected %v %q", c.host, h.aliases, h.redirectFrom, c.aliases, c.redirectFrom)
+		}
+	}
+}
+
+// testRedirectServer renders servers of example.com with the alias and the redirect from www.example.com
+func testRedirectServer(t *testing.T, opts *ProtoOpts, https bool) string {
+	services := newServices([]*core.Service{testService("svc")})
+	server := newServer("example.com", opts, &RenderOpts{})
+	server.aliases = []string{"example.org"}
+	server.redirectFrom = "www.example.com"
+	if https {
+		server.sslCertPath, server.sslCertKeyPath = "/certs/tls.crt", "/certs/tls.key"
+		server.redirectHttps = true
+	}
+	server.addRoute(testRoute(services, networking.PathTypeExact, "/", "svc"))
+	var sb strings.Builder
+	if err := conf.Render(&sb, server.directives()...); err != nil {
+		t.Fatal(err)
+	}
+	return sb.String()
+}
+
+func TestRedirectServers(t *testing.T) {
+	location := ` location = "/" {
+  proxy_http_version 1.1;
+  proxy_set_header Host $http_host;
+  proxy_pass "http://svc.ns:80";
+ }
+`
+	config := testRedirectServer(t, &ProtoOpts{unsecurePort: 80, securePort: 443}, false)
+	expected := `
+server {
+ listen 80;
+ server_name "www.example.com";
+ return 308 "http://example.com$request_uri";
+}
+
+server {
+ listen 80;
+ server_name "example.com" "example.org";
+` + location + `}
+`
+	if config != expected {
+		t.Errorf("http: rendered\n%v\nexpected\n%v", config, expected)
+	}
+
+	// http request to www takes two hops on :80: to http of the host, then to https of it
+	config = testRedirectServer(t, &ProtoOpts{unsecurePort: 80, securePort: 443}, true)
+	expected = `
+server {
+ listen 80;
+ server_name "www.example.com";
+ return 308 "http://example.com$request_uri";
+}
+
+server {
+ listen 443 ssl;
+ server_name "www.example.com";
+ ssl_certificate "/certs/tls.crt";
+ ssl_certificate_key "/certs/tls.key";
+ return 308 "https://example.com$request_uri";
+}
+
+server {
+ listen 80;
+ server_name "example.com" "example.org";
+ return 301 https://$host$request_uri;
+}
+
+server {
+ ssl_protocols TLSv1.2 TLSv1.3;
+ ssl_session_timeout 10m;
+ ssl_session_cache shared:SSL:10m;
+ listen 443 ssl;
+ server_name "example.com" "example.org";
+ ssl_certificate "/certs/tls.crt";
+ ssl_certificate_key "/certs/tls.key";
+` + location + `}
+`
+	if config != expected {
+		t.Errorf("https: rendered\n%v\nexpected\n%v", config, expected)
+	}
+
+	// non-standard ports are kept
+	config = testRedirectServer(t, &ProtoOpts{unsecurePort: 8080, securePort: 8443}, true)
+	for _, expected := range []string{`return 308 "http://example.com:8080$request_uri";`, `return 308 "https://example.com:8443$request_uri";`} {
+		if !strings.Contains(config, expected) {
+			t.Errorf("no %v in\n%v", expected, config)
+		}
+	}
+}
@@ -10,6 +10,14 @@ type Annotations struct {
 	hostAffinity string
 	unixSocket   string
 	staticSite   string
+	// "true" or "false" overrides -prefer-local-endpoints, empty - not set
+	localEndpoints string
+	// regex mode of ImplementationSpecific paths of the ingress: "true" or "case-insensitive", not merged
+	regex         string
+	rewriteTarget string // replacement of rewrite by the path regex, not merged
+	aliases       string // comma separated extra server names of the host, merged as union
+	fromToWWW     bool   // redirect from the other www form of the host
+	upstreamTLS   bool   // https to ExternalName services of the ingress, not merged
 }
 
 func parsePort(annotations map[string]string, key string, portType string, defaultValue uint16) uint16 {
@@ -38,6 +46,13 @@ func newAnnotations(annotations map[string]string) *Annotations {
 		hostAffinity: annotations["ngress.affinity/host"],
 		unixSocket:   annotations["ngress.unix/socket"],
 		staticSite:   annotations["ngress.static/site"],
+
+		localEndpoints: annotations["ngress.upstream/local-endpoints"],
+		regex:          annotations["ngress.path/regex"],
+		rewriteTarget:  annotations["ngress.path/rewrite-target"],
+		aliases:        annotations["ngress.server/aliases"],
+		fromToWWW:      annotations["ngress.server/from-to-www-redirect"] == "true",
+		upstreamTLS:    annotations["ngress.upstream/tls"] == "true",
 	}
 }
 
@@ -50,6 +65,24 @@ func (c *Annotations) string() string {
 	if c.unixSocket != "" {
 		s += " unixSocket: " + c.unixSocket
 	}
+	if c.localEndpoints != "" {
+		s += " localEndpoints: " + c.localEndpoints
+	}
+	if c.regex != "" {
+		s += " regex: " + c.regex
+	}
+	if c.rewriteTarget != "" {
+		s += " rewriteTarget: " + c.rewriteTarget
+	}
+	if c.aliases != "" {
+		s += " aliases: " + c.aliases
+	}
+	if c.fromToWWW {
+		s += " fromToWWW: true"
+	}
+	if c.upstreamTLS {
+		s += " upstreamTLS: true"
+	}
 	return s + " ]"
 }
 
@@ -60,5 +93,24 @@ func (c *Annotations) merge(a *Annotations) {
 	if len(a.unixSocket) > 0 {
 		c.unixSocket = a.unixSocket
 	}
+	if len(a.localEndpoints) > 0 {
+		c.localEndpoints = a.localEndpoints
+	}
+	if len(a.aliases) > 0 {
+		if len(c.aliases) > 0 && c.aliases != a.aliases {
+			c.aliases += "," + a.aliases
+		} else {
+			c.aliases = a.aliases
+		}
+	}
+	c.fromToWWW = c.fromToWWW || a.fromToWWW
 	c.proto.merge(&a.proto)
 }
+
+// preferLocalEndpoints returns the annotation value if it is set, defaultValue otherwise
+func (c *Annotations) preferLocalEndpoints(defaultValue bool) bool {
+	if len(c.localEndpoints) == 0 {
+		return defaultValue
+	}
+	return c.localEndpoints == "true"
+}
@@ -2,64 +2,137 @@ package nginx
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
-	core "k8s.io/api/core/v1"
 	networking "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
 	"k8s.io/klog/v2"
+	"ngress/internal/conf"
+	"ngress/internal/dns"
+	"ngress/internal/metrics"
+	"ngress/internal/traffic"
 	"ngress/internal/utils"
 	"os"
-	"path/filepath"
 	"reflect"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 )
 
+const reconcileKey = "ngress"
+
+// Controller rebuilds nginx configuration from the informers caches.
+// Informer callbacks only enqueue reconcileKey, all the state is owned by the single reconcile worker.
 type Controller struct {
-	mu      sync.Mutex
-	chStop  chan struct{}
-	factory informers.SharedInformerFactory
+	mu       sync.Mutex         // guards served and applyErr
+	served   []cache.ObjectName // namespace/name of ingresses served by the last reconcile
+	applyErr error              // error of the last config apply, nil if applied
+	chStop   chan struct{}
+	factory  informers.SharedInformerFactory
+	queue    workqueue.TypedRateLimitingInterface[string]
+	wg       sync.WaitGroup
+	ready    atomic.Bool // caches synced and the first config applied
+	// unix nano time of the first event not taken by reconcile yet
+	firstEvent atomic.Int64
 
 	ingresses map[string]*Ingress
-	hosts     map[string]*Host
-	services  map[string]struct{}
+	services  *Services
+	endpoints *Endpoints // nil if upstreams of endpoints disabled
 	certs     map[string][]byte
 
 	secrets       *Secrets
+	classes       *IngressClasses
+	status        *StatusWriter
+	validator     *Validator
 	opts          *Opts
-	debounced     func(f func())
 	configData    []byte
-	certsCheckSum uint32
+	reloadPending bool
 	hostname      string
+	node          string // node of the pod, endpoints on it are local
+
+	rejectedConfig string // last config rejected by 'nginx -t'
+	rejectedCerts  map[string][]byte
+	quarantined    map[string]*Quarantined
+	events         *Events
+	metrics        *Metrics
+	applied        map[string]int64 // ingress name -> generation in the last applied config, nil before the first
+	traffic        *traffic.Receiver
+	dns            *dns.Server
+	resolver       string // nginx resolver of ExternalName services
+	generations    *Generations
+	pendingEvent   int64 // unix nano time of the first event not applied yet
 }
 
-func NewConfigController(opts *Opts, kubeClient kubernetes.Interface) *Controller {
+func NewConfigController(opts *Opts, kubeClient kubernetes.Interface, registry *metrics.Registry) *Controller {
 	hostname, err := os.Hostname()
 	if err != nil {
 		klog.Fatalf("error getting hostname: %v", err)
 	}
+	// NODE_NAME of spec.nodeName by the downward API, the hostname equals it with hostNetwork only
+	node := os.Getenv("NODE_NAME")
+	if len(node) == 0 {
+		klog.Infof("NODE_NAME not set, hostname %v used as the node name", hostname)
+		node = hostname
+	}
+	factory := informers.NewSharedInformerFactory(kubeClient, 30*time.Second)
+	keys := newKeys(*opts.keysDir, *opts.keyUID, *opts.keyGID)
 	c := &Controller{
-		factory:   informers.NewSharedInformerFactory(kubeClient, 30*time.Second),
+		factory:   factory,
 		chStop:    make(chan struct{}),
+		queue:     workqueue.NewTypedRateLimitingQueue(workqueue.DefaultTypedControllerRateLimiter[string]()),
 		ingresses: make(map[string]*Ingress),
-		services:  make(map[string]struct{}),
-		hosts:     make(map[string]*Host),
+		services:  newServices(nil),
 		certs:     make(map[string][]byte),
-		secrets:   newSecrets(),
-		debounced: utils.NewDebounce(*opts.reloadDebounceInterval),
-		opts:      opts,
-		hostname:  hostname,
+		secrets:   newSecrets(factory.Core().V1().Secrets().Lister(), *opts.defaultSSLCertificate, *opts.tlsFallback),
+		classes: newIngressClasses(*opts.ingressClass, *opts.controllerName,
+			factory.Networking().V1().IngressClasses().Lister()),
+		validator:   newValidator(*opts.nginxBinary, *opts.nginxTestDir, keys),
+		generations: newGenerations(*opts.certsDir, *opts.confDir, *opts.keepGenerations, keys),
+		opts:        opts,
+		hostname:    hostname,
+		node:        node,
+
+		quarantined: make(map[string]*Quarantined),
+		events:      newEvents(kubeClient, hostname),
+		metrics:     newMetrics(registry),
+		resolver:    externalResolver(*opts.externalNameResolver),
+	}
+
+	if len(*opts.trafficSyslogAddress) > 0 {
+		c.traffic, err = traffic.NewReceiver(*opts.trafficSyslogAddress, registry)
+		if err != nil {
+			klog.Fatalf("error: %v, starting traffic receiver", err)
+		}
 	}
 
 	sharedInformers := []cache.SharedInformer{
 		c.factory.Networking().V1().Ingresses().Informer(),
+		c.factory.Networking().V1().IngressClasses().Informer(),
 		c.factory.Core().V1().Secrets().Informer(),
 		c.factory.Core().V1().Services().Informer(),
 	}
+	if *opts.upstreamEndpoints {
+		c.endpoints = newEndpoints(nil)
+		sharedInformers = append(sharedInformers, c.factory.Discovery().V1().EndpointSlices().Informer())
+	} else if *opts.preferLocalEndpoints || len(*opts.dnsAddress) > 0 {
+		klog.Warningf("-prefer-local-endpoints and -dns-address ignored, require -upstream-endpoints")
+	}
+	if *opts.upstreamEndpoints && len(*opts.dnsAddress) > 0 {
+		c.dns, err = dns.NewServer(*opts.dnsAddress)
+		if err != nil {
+			klog.Fatalf("error: %v, starting dns server", err)
+		}
+		if *opts.preferLocalEndpoints {
+			klog.Warningf("-prefer-local-endpoints ignored, endpoints are resolved by dns")
+		}
+	}
 	for _, informer := range sharedInformers {
 		_, err = informer.AddEventHandler(c)
 		if err != nil {
@@ -68,176 +141,429 @@ func NewConfigController(opts *Opts, kubeClient kubernetes.Interface) *Controlle
 	}
 
 	c.factory.Start(c.chStop)
-	klog.Infof("started on host: %s success", hostname)
+	c.wg.Add(1)
+	go c.run()
+	if *opts.updateStatus {
+		c.status = newStatusWriter(opts, kubeClient, c)
+		c.status.start()
+	}
+	klog.Infof("started on host: %s, ingress class: %v, controller: %v success",
+		hostname, *opts.ingressClass, *opts.controllerName)
 
 	return c
 }
 
-func (c *Controller) Stop() {
-	close(c.chStop)
-	c.factory.Shutdown()
+// Ready returns true when informers caches are synced and the first consistent config is applied
+func (c *Controller) Ready() bool {
+	return c.ready.Load()
 }
 
-func (c *Controller) add(obj interface{}) {
-	switch v := obj.(type) {
-	case *networking.Ingress:
-		c.OnAddIngress(v)
-	case *core.Secret:
-		c.secrets.add(v)
-	case *core.Service:
-		service := serviceString(v)
-		klog.Infof("added service: %v", service)
-		c.services[service] = struct{}{}
+// CheckReady returns nil if the controller is ready and the current config is applied
+func (c *Controller) CheckReady() error {
+	if !c.Ready() {
+		return errors.New("caches not synced or the first config not applied")
+	}
+	state := c.ConfigState()
+	if !state.Valid {
+		return errors.New(fmt.Sprintf("current config rejected by nginx: %v", state.Error))
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.applyErr != nil {
+		return errors.New(fmt.Sprintf("current config not applied: %v", c.applyErr))
 	}
+	return nil
 }
 
-func (c *Controller) remove(obj interface{}) {
-	switch v := obj.(type) {
-	case *networking.Ingress:
-		c.OnRemoveIngress(v)
-	case *core.Secret:
-		c.secrets.remove(v)
-	case *core.Service:
-		service := serviceString(v)
-		klog.Infof("removed service: %v", service)
-		delete(c.services, service)
+// ConfigState returns result of the last 'nginx -t' validation
+func (c *Controller) ConfigState() ConfigState {
+	return c.validator.State()
+}
+
+func (c *Controller) Stop() {
+	if c.status != nil {
+		c.status.stop()
+	}
+	c.queue.ShutDown()
+	close(c.chStop)
+	c.factory.Shutdown()
+	c.wg.Wait()
+	c.events.stop()
+	if c.traffic != nil {
+		c.traffic.Stop()
+	}
+	if c.dns != nil {
+		c.dns.Stop()
 	}
 }
 
-func (c *Controller) OnAdd(obj interface{}, _ bool) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	c.add(obj)
-	c.debounced(c.debounce)
+// enqueue schedules reconcile, changes arrived during the debounce interval are coalesced
+func (c *Controller) enqueue() {
+	c.firstEvent.CompareAndSwap(0, time.Now().UnixNano())
+	c.queue.AddAfter(reconcileKey, *c.opts.reloadDebounceInterval)
 }
 
-func (c *Controller) OnUpdate(old interface{}, obj interface{}) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
+func (c *Controller) OnAdd(_ interface{}, _ bool) {
+	c.enqueue()
+}
 
-	if reflect.DeepEqual(old, obj) {
+func (c *Controller) OnUpdate(old interface{}, obj interface{}) {
+	if reflect.DeepEqual(old, obj) || !ingressSpecChanged(old, obj) || !endpointSliceChanged(old, obj) {
 		return
 	}
+	c.enqueue()
+}
 
-	c.remove(old)
-	c.add(obj)
-	c.debounced(c.debounce)
+func (c *Controller) OnDelete(_ interface{}) {
+	c.enqueue()
 }
 
-func (c *Controller) OnDelete(obj interface{}) {
+// ingressSpecChanged returns false if only status of the ingress changed (by StatusWriter)
+func ingressSpecChanged(old interface{}, obj interface{}) bool {
+	oldIngress, ok := old.(*networking.Ingress)
+	if !ok {
+		return true
+	}
+	ingress, ok := obj.(*networking.Ingress)
+	if !ok {
+		return true
+	}
+	return !reflect.DeepEqual(oldIngress.Spec, ingress.Spec) ||
+		!reflect.DeepEqual(oldIngress.Annotations, ingress.Annotations)
+}
+
+// servedIngresses returns actual ingress objects served by this controller during the last reconcile,
+// status only updates do not trigger reconcile, so objects are read from the lister
+func (c *Controller) servedIngresses() []*networking.Ingress {
 	c.mu.Lock()
-	defer c.mu.Unlock()
+	served := c.served
+	c.mu.Unlock()
 
-	c.remove(obj)
-	c.debounced(c.debounce)
+	lister := c.factory.Networking().V1().Ingresses().Lister()
+	ingresses := make([]*networking.Ingress, 0, len(served))
+	for _, name := range served {
+		actual, err := lister.Ingresses(name.Namespace).Get(name.Name)
+		if err != nil {
+			continue // removed
+		}
+		ingresses = append(ingresses, actual)
+	}
+	return ingresses
 }
 
 func ingressName(ingress *networking.Ingress) string {
 	return fmt.Sprintf("%s.%s", ingress.Namespace, ingress.Name)
 }
 
-func (c *Controller) OnAddIngress(ingress *networking.Ingress) {
-	name := ingressName(ingress)
-	ing, ok := c.ingresses[name]
-	if !ok {
-		ing = newIngress(ingress, c.hosts, c.secrets, c.services, c.hostname)
-		c.ingresses[name] = ing
-	} else {
-		klog.Warningf("INGRESS:%v.%v already exists", ingress.Namespace, ingress.Name)
+func (c *Controller) run() {
+	defer c.wg.Done()
+	if !c.waitForCacheSync() {
+		return
 	}
+
+	c.queue.Add(reconcileKey) // first render right after the sync, without debounce
+	for c.processNextItem() {
+	}
+	klog.Infof("reconcile worker stopped")
 }
 
-func (c *Controller) OnRemoveIngress(ingress *networking.Ingress) {
-	name := ingressName(ingress)
-	ing, ok := c.ingresses[name]
-	if ok {
-		ing.Remove(ingress)
+// waitForCacheSync prevents render of a partial config, returns false if the controller stopped
+func (c *Controller) waitForCacheSync() bool {
+	timeout := *c.opts.cacheSyncTimeout
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	go func() {
+		select {
+		case <-c.chStop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	klog.Infof("not ready, waiting for caches sync")
+	for informerType, synced := range c.factory.WaitForCacheSync(ctx.Done()) {
+		if synced {
+			continue
+		}
+		select {
+		case <-c.chStop:
+			return false
+		default:
+			klog.Fatalf("error: caches not synced in %v, informer: %v", timeout, informerType)
+		}
 	}
-	delete(c.ingresses, name)
+	klog.Infof("caches synced")
+	return true
 }
 
-func (c *Controller) debounce() {
-	certs := make(map[string][]byte)
-	var sb strings.Builder
-	c.secrets.resetWriteMarks() // during buildServers marks for needed secrets will be set
-	for _, host := range utils.SortedArrayFromMap(c.hosts) {
-		host.buildServers(*c.opts.certsDir, &sb)
+func (c *Controller) processNextItem() bool {
+	key, quit := c.queue.Get()
+	if quit {
+		return false
 	}
-	c.secrets.fillCerts(*c.opts.certsDir, certs)
-	c.applyNginxConfiguration(sb.String(), certs)
+	defer c.queue.Done(key)
+
+	start := time.Now()
+	err := c.reconcile()
+	c.metrics.observeReconcile(start, err)
+	if err != nil {
+		klog.Errorf("error: %v, reconcile retry #%d", err, c.queue.NumRequeues(key)+1)
+		c.queue.AddRateLimited(key)
+		return true
+	}
+	c.queue.Forget(key)
+	return true
 }
 
-func (c *Controller) nginxReload() {
-	pid, err := utils.GetPidFromFile(*c.opts.pidFileName)
+// reconcile rebuilds the desired state from the listers and applies it to nginx
+func (c *Controller) reconcile() error {
+	if eventTime := c.firstEvent.Swap(0); c.pendingEvent == 0 {
+		c.pendingEvent = eventTime
+	}
+
+	services, err := c.factory.Core().V1().Services().Lister().List(labels.Everything())
 	if err != nil {
-		klog.Errorf("error getting nginx pid: %v", err)
-		return
+		return err
 	}
+	c.services = newServices(services)
 
-	klog.Infof("sending 'nginx -s reload' to nginx pid: %d", pid)
-	err = syscall.Kill(pid, syscall.SIGHUP)
+	if c.endpoints != nil {
+		slices, err := c.factory.Discovery().V1().EndpointSlices().Lister().List(labels.Everything())
+		if err != nil {
+			return err
+		}
+		c.endpoints = newEndpoints(slices)
+	}
+
+	ingresses, err := c.factory.Networking().V1().Ingresses().Lister().List(labels.Everything())
 	if err != nil {
-		klog.Errorf("error send SIGHUP to nginx: %v pid: %v", err, pid)
-		return
+		return err
+	}
+	c.ingresses = make(map[string]*Ingress)
+	served := make([]cache.ObjectName, 0, len(ingresses))
+	ownDefault := c.classes.haveOwnDefault()
+	for _, ingress := range ingresses {
+		name := ingressName(ingress)
+		if !c.classes.accept(ingress, ownDefault) {
+			klog.V(2).Infof("INGRESS:%v skip, belongs to another ingress class", name)
+			continue
+		}
+		c.ingresses[name] = newIngress(ingress, c.secrets, c.services, c.hostname)
+		served = append(served, cache.MetaObjectToName(ingress))
 	}
+	c.releaseChanged()
+
+	c.mu.Lock()
+	c.served = served
+	c.mu.Unlock()
+
+	return c.sync()
 }
 
-func (c *Controller) writeCerts() {
-	_ = utils.RemoveGlob(fmt.Sprintf("%v/*", *c.opts.certsDir))
+// render builds nginx config and certificates map: path -> data, paths are based on certsDir
+func (c *Controller) render(hosts map[string]*Host, certsDir string) (string, map[string][]byte) {
+	certs := make(map[string][]byte)
+	opts := &RenderOpts{certsDir: certsDir, ipv6: *c.opts.listenIPv6, trafficLog: c.traffic != nil,
+		externalResolver: c.resolver, externalValid: *c.opts.externalNameInterval}
+	if c.dns != nil {
+		opts.endpoints = c.endpoints
+		opts.resolver = c.dns.Address()
+	} else if c.endpoints != nil {
+		opts.endpoints = c.endpoints
+		opts.keepalive = *c.opts.upstreamKeepalive
+		opts.localEndpoints = *c.opts.preferLocalEndpoints
+		opts.node = c.node
+		opts.upstreams = make(map[string]*Upstream)
+	}
+	var servers strings.Builder
+	c.secrets.reset() // during buildServers marks for needed secrets will be set
+	for _, host := range utils.SortedArrayFromMap(hosts) {
+		if err := conf.Render(&servers, host.buildServers(opts)...); err != nil {
+			klog.Errorf("%v> servers skipped: %v", host.tag, err)
+		}
+	}
 
-	for path, cert := range c.certs {
-		dir := filepath.Dir(path)
-		err := os.MkdirAll(dir, 0755)
-		if err != nil {
-			klog.Errorf("error: %v, creating directory: %v", err, dir)
+	// the dollar variable escapes '$' of ingress values
+	header := []*conf.Directive{conf.Dollar()}
+	if c.traffic != nil {
+		header = append(header, c.traffic.NginxConfig()...)
+	}
+	for _, upstream := range utils.SortedArrayFromMap(opts.upstreams) {
+		header = append(header, upstream.directive(opts.keepalive))
+	}
+	var sb strings.Builder
+	if err := conf.Render(&sb, header...); err != nil {
+		klog.Errorf("error: '%v' rendering http level directives", err)
+	}
+	sb.WriteString(servers.String())
+	c.secrets.fillCerts(certsDir, certs)
+	c.metrics.renders.Inc()
+	return sb.String(), certs
+}
+
+// sync renders the config of all not quarantined ingresses, validates and applies it
+func (c *Controller) sync() error {
+	hosts := c.activeHosts()
+	c.secrets.prune(hosts)
+	config, certs := c.render(hosts, c.generations.currentDir())
+	for c.changed(config, certs) && !c.validate(hosts, config, certs) {
+		// isolate the ingress which breaks the config and render without it
+		if !c.quarantineBroken() {
+			return nil // not caused by a single ingress, last-known-good config kept
+		}
+		hosts = c.activeHosts()
+		config, certs = c.render(hosts, c.generations.currentDir())
+	}
+	if c.dns != nil {
+		c.dns.Update(dnsRecords(hosts, c.endpoints))
+	}
+
+	reloaded, err := c.applyNginxConfiguration(config, certs)
+	c.mu.Lock()
+	c.applyErr = err
+	c.mu.Unlock()
+	if err == nil {
+		c.metrics.observeApplied(config, hosts, c.secrets.used(), len(c.quarantined))
+		if c.traffic != nil {
+			c.traffic.Retain(trafficRoutes(hosts))
+		}
+	}
+	c.report(hosts, reloaded, err)
+	return err
+}
+
+// report attaches events to the ingresses: problems of the applied config,
+// result of the reload to the ingresses changed since the last applied config
+func (c *Controller) report(hosts map[string]*Host, reloaded bool, err error) {
+	for _, host := range utils.SortedArrayFromMap(hosts) {
+		host.report(c.events)
+	}
+
+	for _, name := range utils.SortedKeys(c.ingresses) {
+		if ingress := c.ingresses[name]; ingress.rejected != nil {
+			c.events.warning(ingress.ingress, reasonRejected,
+				fmt.Sprintf("excluded from nginx config: %v", ingress.rejected))
+		}
+	}
+
+	applied := make(map[string]int64)
+	for _, name := range c.contributing() {
+		ingress := c.ingresses[name]
+		c.events.report(ingress.problems)
+
+		applied[name] = ingress.ingress.Generation
+		if c.applied == nil && err == nil {
+			continue // the first config after start, nothing changed for the ingresses
+		}
+		if generation, ok := c.applied[name]; ok && generation == ingress.ingress.Generation {
+			continue
 		}
-		err = os.WriteFile(path, cert, 0644)
 		if err != nil {
-			klog.Errorf("error: %v, writing certificate to file: %v", err, path)
-		} else {
-			klog.Infof("success writing certificate to file: %v", path)
+			c.events.warning(ingress.ingress, reasonReloadFailed,
+				fmt.Sprintf("nginx config on host %v not applied: %v", c.hostname, err))
+		} else if reloaded {
+			c.events.normal(ingress.ingress, reasonReloaded,
+				fmt.Sprintf("nginx config reloaded on host %v", c.hostname))
 		}
 	}
+	if err == nil {
+		c.applied = applied
+	}
 }
 
-func (c *Controller) applyNginxConfiguration(config string, certs map[string][]byte) {
+func (c *Controller) changed(config string, certs map[string][]byte) bool {
+	return config != string(c.configData) || !reflect.DeepEqual(c.certs, certs)
+}
 
-	certsChanged := !reflect.DeepEqual(c.certs, certs)
-	c.certs = certs
-	if certsChanged {
-		klog.Infof("certificates changed")
-		c.writeCerts()
+// validate checks the config with 'nginx -t', rejected config is not checked again
+func (c *Controller) validate(hosts map[string]*Host, config string, certs map[string][]byte) bool {
+	if !c.validator.enabled() {
+		return true
+	}
+	if c.rejectedConfig == config && reflect.DeepEqual(c.rejectedCerts, certs) {
+		klog.Warningf("nginx config already rejected, last-known-good config kept")
+		return false
+	}
+
+	stagedConfig, stagedCerts := c.render(hosts, c.validator.certsDir())
+	err := c.validator.validate(stagedConfig, stagedCerts)
+	c.metrics.observeValidation(err)
+	if err != nil {
+		c.rejectedConfig = config
+		c.rejectedCerts = certs
+		return false
+	}
+	c.rejectedConfig = ""
+	c.rejectedCerts = nil
+	return true
+}
+
+func (c *Controller) nginxReload() error {
+	pid, err := utils.GetPidFromFile(*c.opts.pidFileName)
+	if err != nil {
+		return err
+	}
+
+	klog.Infof("sending 'nginx -s reload' to nginx pid: %d", pid)
+	err = syscall.Kill(pid, syscall.SIGHUP)
+	if err != nil {
+		return errors.New(fmt.Sprintf("error: '%v' send SIGHUP to nginx pid: %v", err, pid))
 	}
+	return nil
+}
 
+// applyNginxConfiguration writes the config and certificates, reloads nginx if anything changed
+func (c *Controller) applyNginxConfiguration(config string, certs map[string][]byte) (bool, error) {
 	configData := []byte(config)
+	certsChanged := !reflect.DeepEqual(c.certs, certs)
 	configChanged := bytes.Compare(c.configData, configData) != 0
-	if configChanged {
-		c.configData = configData
-		klog.Infof("nginx config changed\n%v", config)
 
-		path := filepath.Join(*c.opts.confDir, "ngress.conf")
-		err := os.WriteFile(path, configData, 0644)
+	if certsChanged || configChanged {
+		if certsChanged {
+			klog.Infof("certificates changed")
+		}
+		if configChanged {
+			klog.Infof("nginx config changed\n%v", config)
+		}
+		err := c.generations.apply(configData, certs)
 		if err != nil {
-			klog.Errorf("error writing ngress.conf: %v", err)
-			return
+			return false, err
 		}
+		c.reloadPending = true
 	} else {
 		klog.Infof("nginx config not changed")
 	}
-
-	if configChanged || certsChanged {
-		c.nginxReload()
+	if certsChanged {
+		c.certs = certs
+		c.metrics.certChanges.Inc()
+	}
+	if configChanged {
+		c.configData = configData
+		c.metrics.configChanges.Inc()
 	}
-}
 
-func servicePort(v *core.Service) int32 {
-	var port int32
-	if len(v.Spec.Ports) > 0 {
-		port = v.Spec.Ports[0].Port
+	reloaded := c.reloadPending
+	if c.reloadPending {
+		c.metrics.reloads.Inc()
+		err := c.nginxReload()
+		if err != nil {
+			c.metrics.reloadFailures.Inc()
+			// nginx must not pick up the new generation by its own reload, the next sync stages it again
+			if rollbackErr := c.generations.rollback(); rollbackErr == nil {
+				c.configData, c.certs = nil, nil
+			}
+			return false, err
+		}
+		c.reloadPending = false
+		if c.pendingEvent != 0 {
+			c.metrics.eventToReload.Observe(time.Since(time.Unix(0, c.pendingEvent)).Seconds())
+		}
 	}
-	return port
-}
+	c.pendingEvent = 0
 
-func serviceString(v *core.Service) string {
-	return fmt.Sprintf("%v.%v:%v", v.Name, v.Namespace, servicePort(v))
+	if !c.ready.Swap(true) {
+		c.metrics.ready.Set(1)
+		klog.Infof("ready, the first config applied")
+	}
+	return reloaded, nil
 }
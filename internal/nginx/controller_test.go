@@ -0,0 +1,268 @@
+package nginx
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	networking "k8s.io/api/networking/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes/fake"
+	"ngress/internal/metrics"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+var (
+	testOptsOnce sync.Once
+	testOpts     *Opts
+)
+
+// testOptions returns options of the flags registered once
+func testOptions() *Opts {
+	testOptsOnce.Do(func() {
+		testOpts = NewOpts()
+	})
+	return testOpts
+}
+
+// testController starts the controller of the fake clientset, nginx is the test process ignoring SIGHUP
+func testController(t *testing.T, objects ...runtime.Object) (*Controller, *fake.Clientset, string) {
+	testOptions()
+	signal.Ignore(syscall.SIGHUP)
+	dir := t.TempDir()
+	confDir, certsDir, pidFile := filepath.Join(dir, "conf"), filepath.Join(dir, "certs"), filepath.Join(dir, "pid")
+	for _, d := range []string{confDir, certsDir} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(pidFile, []byte(fmt.Sprintf("%d\n", os.Getpid())), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for name, value := range map[string]string{"nginx-confd-dir": confDir, "nginx-certs-dir": certsDir,
+		"nginx-pid-file": pidFile, "nginx-reload-debounce-interval": "1ms", "update-status": "false"} {
+		if err := flag.Set(name, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	objects = append(objects, &networking.IngressClass{ObjectMeta: meta.ObjectMeta{Name: "ngress"},
+		Spec: networking.IngressClassSpec{Controller: *testOpts.controllerName}})
+	client := fake.NewSimpleClientset(objects...)
+	c := NewConfigController(testOpts, client, metrics.NewRegistry())
+	t.Cleanup(c.Stop)
+	return c, client, filepath.Join(confDir, configName)
+}
+
+func testIngress(name string, host string, service string) *networking.Ingress {
+	class := "ngress"
+	pathType := networking.PathTypePrefix
+	return &networking.Ingress{ObjectMeta: meta.ObjectMeta{Name: name, Namespace: "ns"},
+		Spec: networking.IngressSpec{IngressClassName: &class, Rules: []networking.IngressRule{{Host: host,
+			IngressRuleValue: networking.IngressRuleValue{HTTP: &networking.HTTPIngressRuleValue{
+				Paths: []networking.HTTPIngressPath{{Path: "/", PathType: &pathType,
+					Backend: networking.IngressBackend{Service: &networking.IngressServiceBackend{
+						Name: service, Port: networking.ServiceBackendPort{Number: 80}}}}}}}}}}}
+}
+
+// waitConfig waits until the applied config contains all the strings
+func waitConfig(t *testing.T, path string, timeout time.Duration, contains ...string) {
+	deadline := time.Now().Add(timeout)
+	for {
+		data, _ := os.ReadFile(path)
+		missing := ""
+		for _, s := range contains {
+			if !strings.Contains(string(data), s) {
+				missing = s
+				break
+			}
+		}
+		if len(missing) == 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("config has no %q:\n%s", missing, data)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// TestConcurrentEvents drives informer callbacks concurrently with the reconcile worker and the status writer,
+// run with -race to check the state is owned by the worker only
+func TestConcurrentEvents(t *testing.T) {
+	c, client, configPath := testController(t, testService("svc"), testIngress("ing", "a.example.com", "svc"))
+	waitConfig(t, configPath, 5*time.Second, "a.example.com")
+
+	status := &StatusWriter{tag: "STATUS", client: client, controller: c,
+		publishAddrs: []networking.IngressLoadBalancerIngress{loadBalancerIngress("192.0.2.1")}}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for ctx.Err() == nil {
+			status.update(ctx)
+			time.Sleep(time.Millisecond)
+		}
+	}()
+
+	for worker := 0; worker < 4; worker++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				name := fmt.Sprintf("ing-%d-%d", worker, i%5)
+				ingress := testIngress(name, fmt.Sprintf("%v.example.com", name), "svc")
+				service := testService(fmt.Sprintf("svc-%d", worker))
+				service.Spec.Ports[0].TargetPort = intstr.FromInt32(int32(8000 + i))
+
+				// the informers callbacks
+				if _, err := client.NetworkingV1().Ingresses("ns").Create(ctx, ingress, meta.CreateOptions{}); err != nil {
+					ingress.Annotations = map[string]string{"ngress.server/aliases": fmt.Sprintf("www.%v.example.com", name)}
+					_, _ = client.NetworkingV1().Ingresses("ns").Update(ctx, ingress, meta.UpdateOptions{})
+				}
+				if _, err := client.CoreV1().Services("ns").Create(ctx, service, meta.CreateOptions{}); err != nil {
+					_, _ = client.CoreV1().Services("ns").Update(ctx, service, meta.UpdateOptions{})
+				}
+				if i%3 == 0 {
+					_ = client.NetworkingV1().Ingresses("ns").Delete(ctx, name, meta.DeleteOptions{})
+				}
+
+				// the callbacks directly, as informers of different kinds call them concurrently
+				c.OnAdd(ingress, false)
+				c.OnUpdate(service, ingress)
+				c.OnDelete(service)
+				time.Sleep(time.Millisecond)
+			}
+		}(worker)
+	}
+
+	// the controller keeps applying the state after the churn
+	if _, err := client.NetworkingV1().Ingresses("ns").Create(ctx, testIngress("last", "last.example.com", "svc"),
+		meta.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	waitConfig(t, configPath, 10*time.Second, "a.example.com", "last.example.com")
+	cancel()
+	wg.Wait()
+}
+
+// waitReady waits until CheckReady returns nil or an error as expected
+func waitReady(t *testing.T, c *Controller, ready bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		err := c.CheckReady()
+		if (err == nil) == ready {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("CheckReady: %v, expected ready: %v", err, ready)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestCheckReadyAfterApplyFailure(t *testing.T) {
+	c, client, configPath := testController(t, testService("svc"), testIngress("ing", "a.example.com", "svc"))
+	waitConfig(t, configPath, 5*time.Second, "a.example.com")
+	waitReady(t, c, true)
+
+	// nginx reload fails
+	pidFile := *testOpts.pidFileName
+	pid, err := os.ReadFile(pidFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(pidFile, []byte("not a pid"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if _, err = client.NetworkingV1().Ingresses("ns").Create(ctx, testIngress("b", "b.example.com", "svc"),
+		meta.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	waitReady(t, c, false)
+
+	// the next apply succeeds
+	if err = os.WriteFile(pidFile, pid, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = client.NetworkingV1().Ingresses("ns").Create(ctx, testIngress("c", "c.example.com", "svc"),
+		meta.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	waitReady(t, c, true)
+}
+
+// statusUpdates returns number of ingress status updates sent by the client
+func statusUpdates(client *fake.Clientset) int {
+	n := 0
+	for _, action := range client.Actions() {
+		if action.GetVerb() == "update" && action.GetSubresource() == "status" {
+			n++
+		}
+	}
+	return n
+}
+
+// waitStatus waits until the lister of the controller returns the ingress with the status addresses
+func waitStatus(t *testing.T, c *Controller, name string, ip string) {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		ingress, err := c.factory.Networking().V1().Ingresses().Lister().Ingresses("ns").Get(name)
+		if err == nil && len(ingress.Status.LoadBalancer.Ingress) == 1 && ingress.Status.LoadBalancer.Ingress[0].IP == ip {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("status of %v has no %v", name, ip)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestStatusChangedBetweenPasses(t *testing.T) {
+	c, client, configPath := testController(t, testService("svc"), testIngress("ing", "a.example.com", "svc"))
+	waitConfig(t, configPath, 5*time.Second, "a.example.com")
+	waitReady(t, c, true)
+	ctx := context.Background()
+	status := &StatusWriter{tag: "STATUS", client: client, controller: c,
+		publishAddrs: []networking.IngressLoadBalancerIngress{loadBalancerIngress("192.0.2.1")}}
+
+	status.update(ctx)
+	if n := statusUpdates(client); n != 1 {
+		t.Fatalf("status updates %v, expected 1", n)
+	}
+	waitStatus(t, c, "ing", "192.0.2.1")
+
+	// the written status is actual, nothing to update
+	status.update(ctx)
+	if n := statusUpdates(client); n != 1 {
+		t.Fatalf("status updates %v after the same status, expected 1", n)
+	}
+
+	// status is changed by someone else, it is written again over the actual object
+	ingress, err := client.NetworkingV1().Ingresses("ns").Get(ctx, "ing", meta.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ingress.Status.LoadBalancer.Ingress = []networking.IngressLoadBalancerIngress{loadBalancerIngress("192.0.2.9")}
+	if _, err = client.NetworkingV1().Ingresses("ns").UpdateStatus(ctx, ingress, meta.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	waitStatus(t, c, "ing", "192.0.2.9")
+	status.update(ctx)
+	if n := statusUpdates(client); n != 3 {
+		t.Fatalf("status updates %v, expected 3", n)
+	}
+	waitStatus(t, c, "ing", "192.0.2.1")
+}
@@ -0,0 +1,94 @@
+package nginx
+
+import (
+	discovery "k8s.io/api/discovery/v1"
+	"net"
+	"reflect"
+	"strconv"
+)
+
+// Endpoints are ready endpoints of the services from EndpointSlices
+type Endpoints struct {
+	slices map[string][]*discovery.EndpointSlice // service name.namespace -> slices
+}
+
+func newEndpoints(slices []*discovery.EndpointSlice) *Endpoints {
+	c := &Endpoints{slices: make(map[string][]*discovery.EndpointSlice)}
+	for _, slice := range slices {
+		service, ok := slice.Labels[discovery.LabelServiceName]
+		if !ok {
+			continue
+		}
+		name := serviceName(service, slice.Namespace)
+		c.slices[name] = append(c.slices[name], slice)
+	}
+	return c
+}
+
+// addresses returns 'ip:port' -> node name of ready not terminating endpoints of the service port
+func (c *Endpoints) addresses(namespace string, service string, portName string) map[string]string {
+	addresses := make(map[string]string)
+	for _, slice := range c.slices[serviceName(service, namespace)] {
+		if slice.AddressType != discovery.AddressTypeIPv4 && slice.AddressType != discovery.AddressTypeIPv6 {
+			continue
+		}
+		port := slicePort(slice, portName)
+		if port == 0 {
+			continue
+		}
+		for _, endpoint := range slice.Endpoints {
+			if !endpointReady(&endpoint) {
+				continue
+			}
+			node := ""
+			if endpoint.NodeName != nil {
+				node = *endpoint.NodeName
+			}
+			for _, address := range endpoint.Addresses {
+				addresses[net.JoinHostPort(address, strconv.Itoa(int(port)))] = node
+			}
+		}
+	}
+	return addresses
+}
+
+// slicePort returns the target port of the service port name, slice ports are named as service ports
+func slicePort(slice *discovery.EndpointSlice, portName string) int32 {
+	for _, port := range slice.Ports {
+		if port.Port == nil || (port.Protocol != nil && *port.Protocol != "TCP") {
+			continue
+		}
+		name := ""
+		if port.Name != nil {
+			name = *port.Name
+		}
+		if name == portName {
+			return *port.Port
+		}
+	}
+	return 0
+}
+
+// endpointReady is true for ready (unknown readiness is ready) and not terminating endpoint
+func endpointReady(endpoint *discovery.Endpoint) bool {
+	conditions := endpoint.Conditions
+	if conditions.Terminating != nil && *conditions.Terminating {
+		return false
+	}
+	return conditions.Ready == nil || *conditions.Ready
+}
+
+// endpointSliceChanged is false if endpoints of the slice are not changed, true for other objects
+func endpointSliceChanged(old interface{}, obj interface{}) bool {
+	oldSlice, ok := old.(*discovery.EndpointSlice)
+	if !ok {
+		return true
+	}
+	slice, ok := obj.(*discovery.EndpointSlice)
+	if !ok {
+		return true
+	}
+	return !reflect.DeepEqual(oldSlice.Endpoints, slice.Endpoints) ||
+		!reflect.DeepEqual(oldSlice.Ports, slice.Ports) ||
+		!reflect.DeepEqual(oldSlice.Labels, slice.Labels)
+}
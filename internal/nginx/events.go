@@ -0,0 +1,100 @@
+package nginx
+
+import (
+	"fmt"
+	core "k8s.io/api/core/v1"
+	networking "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcore "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+	"time"
+)
+
+const (
+	maxEventMessageLength = 1000
+	eventDedupInterval    = time.Hour // the same event of the same object generation is not repeated during it
+)
+
+// event reasons
+const (
+	reasonRouteSkipped      = "RouteSkipped"
+	reasonDuplicateRoute    = "DuplicateRoute"
+	reasonDefaultBackend    = "DefaultBackendIgnored"
+	reasonInvalidPath       = "InvalidPath"
+	reasonSecretNotFound    = "SecretNotFound"
+	reasonTLSSecretMismatch = "TLSSecretMismatch"
+	reasonTLSSecretInvalid  = "TLSSecretInvalid"
+	reasonQuarantined       = "Quarantined"
+	reasonRejected          = "Rejected"
+	reasonReloaded          = "Reloaded"
+	reasonReloadFailed      = "ReloadFailed"
+)
+
+// Problem is a condition of the ingress found during build of hosts, reported by a warning event
+type Problem struct {
+	ingress *networking.Ingress
+	reason  string
+	message string
+}
+
+// Events attaches kubernetes events to the objects served by the controller
+type Events struct {
+	broadcaster record.EventBroadcaster
+	recorder    record.EventRecorder
+	sent        map[string]time.Time // event key -> time of the last emit
+}
+
+func newEvents(client kubernetes.Interface, hostname string) *Events {
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcore.EventSinkImpl{Interface: client.CoreV1().Events("")})
+	return &Events{
+		broadcaster: broadcaster,
+		recorder:    broadcaster.NewRecorder(scheme.Scheme, core.EventSource{Component: "ngress", Host: hostname}),
+		sent:        make(map[string]time.Time),
+	}
+}
+
+// emit records the event if it was not emitted for the same object generation recently,
+// so periodic resync does not repeat it
+func (c *Events) emit(obj runtime.Object, eventType string, reason string, message string) {
+	if len(message) > maxEventMessageLength {
+		message = message[:maxEventMessageLength] + "..."
+	}
+
+	if accessor, err := meta.Accessor(obj); err == nil {
+		now := time.Now()
+		for key, t := range c.sent {
+			if now.Sub(t) > eventDedupInterval {
+				delete(c.sent, key)
+			}
+		}
+		key := fmt.Sprintf("%v/%v/%v/%v/%v/%v", accessor.GetNamespace(), accessor.GetName(),
+			accessor.GetGeneration(), eventType, reason, message)
+		if _, ok := c.sent[key]; ok {
+			return
+		}
+		c.sent[key] = now
+	}
+	c.recorder.Event(obj, eventType, reason, message)
+}
+
+func (c *Events) normal(obj runtime.Object, reason string, message string) {
+	c.emit(obj, core.EventTypeNormal, reason, message)
+}
+
+func (c *Events) warning(obj runtime.Object, reason string, message string) {
+	c.emit(obj, core.EventTypeWarning, reason, message)
+}
+
+func (c *Events) report(problems []*Problem) {
+	for _, p := range problems {
+		c.warning(p.ingress, p.reason, p.message)
+	}
+}
+
+func (c *Events) stop() {
+	c.broadcaster.Shutdown()
+}
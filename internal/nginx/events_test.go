@@ -0,0 +1,74 @@
+package nginx
+
+import (
+	networking "k8s.io/api/networking/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testEvents returns events recorded by the fake recorder
+func testEvents() (*Events, *record.FakeRecorder) {
+	recorder := record.NewFakeRecorder(10)
+	return &Events{recorder: recorder, sent: make(map[string]time.Time)}, recorder
+}
+
+// testRecorded returns events recorded since the last call
+func testRecorded(recorder *record.FakeRecorder) []string {
+	var events []string
+	for {
+		select {
+		case event := <-recorder.Events:
+			events = append(events, event)
+		default:
+			return events
+		}
+	}
+}
+
+func TestEventDedupe(t *testing.T) {
+	events, recorder := testEvents()
+	ingress := &networking.Ingress{ObjectMeta: meta.ObjectMeta{Name: "ing", Namespace: "ns", Generation: 1}}
+
+	events.warning(ingress, reasonRouteSkipped, "route skipped")
+	events.warning(ingress, reasonRouteSkipped, "route skipped")
+	if recorded := testRecorded(recorder); len(recorded) != 1 || recorded[0] != "Warning RouteSkipped route skipped" {
+		t.Errorf("events %v, expected the single warning", recorded)
+	}
+
+	// other message, reason, type or generation is a new event
+	events.warning(ingress, reasonRouteSkipped, "other route skipped")
+	events.warning(ingress, reasonInvalidPath, "route skipped")
+	events.normal(ingress, reasonRouteSkipped, "route skipped")
+	changed := ingress.DeepCopy()
+	changed.Generation = 2
+	events.warning(changed, reasonRouteSkipped, "route skipped")
+	if recorded := testRecorded(recorder); len(recorded) != 4 {
+		t.Errorf("events %v, expected 4 new events", recorded)
+	}
+
+	// the event is repeated after the dedupe interval
+	for key := range events.sent {
+		events.sent[key] = time.Now().Add(-eventDedupInterval - time.Minute)
+	}
+	events.warning(ingress, reasonRouteSkipped, "route skipped")
+	if recorded := testRecorded(recorder); len(recorded) != 1 {
+		t.Errorf("events %v, expected the repeated warning", recorded)
+	}
+	if len(events.sent) != 1 {
+		t.Errorf("sent %v, expected expired keys removed", len(events.sent))
+	}
+}
+
+func TestEventMessageTruncated(t *testing.T) {
+	events, recorder := testEvents()
+	ingress := &networking.Ingress{ObjectMeta: meta.ObjectMeta{Name: "ing", Namespace: "ns"}}
+	events.warning(ingress, reasonRejected, strings.Repeat("x", maxEventMessageLength+10))
+	recorded := testRecorded(recorder)
+	expected := "Warning Rejected " + strings.Repeat("x", maxEventMessageLength) + "..."
+	if len(recorded) != 1 || recorded[0] != expected {
+		t.Errorf("events %v, expected the truncated message", recorded)
+	}
+}
@@ -0,0 +1,75 @@
+package nginx
+
+import (
+	"bufio"
+	"fmt"
+	"k8s.io/klog/v2"
+	"net"
+	"ngress/internal/conf"
+	"os"
+	"strings"
+)
+
+const resolvConf = "/etc/resolv.conf"
+
+// externalResolver returns the nginx resolver address of ExternalName services: the configured one,
+// the first nameserver of resolv.conf otherwise, empty if there is no nameserver
+func externalResolver(configured string) string {
+	address := configured
+	if len(address) == 0 {
+		address = systemNameserver(resolvConf)
+	}
+	if len(address) == 0 {
+		klog.Warningf("nameserver not found in %v, ExternalName services are resolved on reload only", resolvConf)
+		return ""
+	}
+	// nginx requires IPv6 address in brackets
+	if ip := net.ParseIP(address); ip != nil && ip.To4() == nil {
+		return fmt.Sprintf("[%v]", address)
+	}
+	return address
+}
+
+func systemNameserver(path string) string {
+	file, err := os.Open(path)
+	if err != nil {
+		klog.Warningf("error: '%v' reading %v", err, path)
+		return ""
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) > 1 && fields[0] == "nameserver" {
+			return fields[1]
+		}
+	}
+	return ""
+}
+
+// externalNameProxy returns proxy to ExternalName service, the hostname is resolved at request time
+// if there is a resolver, Host header and SNI are the external hostname
+func (c *Route) externalNameProxy(render *RenderOpts) []*conf.Directive {
+	scheme := "http"
+	if c.upstreamTLS {
+		scheme = "https"
+	}
+	directives := []*conf.Directive{
+		conf.New("proxy_http_version", "1.1"),
+		conf.New("proxy_set_header", "Host", "$proxy_host"),
+	}
+	if len(render.externalResolver) > 0 {
+		directives = append(directives,
+			conf.New("resolver", render.externalResolver, fmt.Sprintf("valid=%vs", int(render.externalValid.Seconds()))),
+			conf.New("set", "$ngress_external").Value(c.destination()),
+			conf.New("proxy_pass", scheme+"://$ngress_external"))
+	} else {
+		directives = append(directives, conf.New("proxy_pass").Value(scheme+"://"+c.destination()))
+	}
+	if c.upstreamTLS {
+		directives = append(directives,
+			conf.New("proxy_ssl_server_name", "on"),
+			conf.New("proxy_ssl_name").Value(c.externalName))
+	}
+	return directives
+}
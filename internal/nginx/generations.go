@@ -0,0 +1,255 @@
+package nginx
+
+import (
+	"errors"
+	"fmt"
+	"k8s.io/klog/v2"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+)
+
+const (
+	generationsDir = "generations"
+	currentLink    = "current" // symlink to the applied generation
+	configName     = "ngress.conf"
+)
+
+// Generations are staged copies of the config with its certificates in certsDir/generations/<N>,
+// the certsDir/current symlink selects the applied one and ngress.conf of conf.d links to current/ngress.conf,
+// so the config and certificates are swapped together by a single rename.
+// Previous generations are kept for inspection and rollback: ln -sfn generations/<N> current
+type Generations struct {
+	dir     string // certs directory
+	confDir string // nginx conf.d directory
+	keep    int    // previous generations kept
+	last    int    // number of the last staged generation
+	keys    *Keys
+}
+
+func newGenerations(dir string, confDir string, keep int, keys *Keys) *Generations {
+	c := &Generations{dir: dir, confDir: confDir, keep: keep, keys: keys}
+	numbers, _ := c.numbers()
+	if len(numbers) > 0 {
+		c.last = numbers[len(numbers)-1]
+	}
+	return c
+}
+
+// currentDir is the certificates directory of the rendered config, resolved by nginx via the current symlink
+func (c *Generations) currentDir() string {
+	return filepath.Join(c.dir, currentLink)
+}
+
+func (c *Generations) path(n int) string {
+	return filepath.Join(c.dir, generationsDir, fmt.Sprintf("%06d", n))
+}
+
+// numbers returns sorted numbers of the existing generations
+func (c *Generations) numbers() ([]int, error) {
+	entries, err := os.ReadDir(filepath.Join(c.dir, generationsDir))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	numbers := make([]int, 0, len(entries))
+	for _, entry := range entries {
+		if n, err := strconv.Atoi(entry.Name()); err == nil && entry.IsDir() {
+			numbers = append(numbers, n)
+		}
+	}
+	slices.Sort(numbers)
+	return numbers, nil
+}
+
+// current returns the number of the generation selected by the current symlink, 0 if there is no one
+func (c *Generations) current() int {
+	target, err := os.Readlink(c.currentDir())
+	if err != nil {
+		return 0
+	}
+	n, _ := strconv.Atoi(filepath.Base(target))
+	return n
+}
+
+// apply stages the config and certificates (paths under currentDir) as a new generation and selects it
+func (c *Generations) apply(config []byte, certs map[string][]byte) error {
+	n := c.last + 1
+	dir := c.path(n)
+	err := c.stage(dir, config, certs)
+	if err != nil {
+		_ = os.RemoveAll(dir)
+		_ = c.keys.remove(filepath.Base(dir))
+		return errors.New(fmt.Sprintf("error: '%v' staging generation: %v", err, dir))
+	}
+	c.last = n
+	err = c.selectGeneration(n)
+	if err != nil {
+		return err
+	}
+	klog.Infof("generation %v applied: %v", n, dir)
+	c.cleanup()
+	return nil
+}
+
+func (c *Generations) stage(dir string, config []byte, certs map[string][]byte) error {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+	dirs := map[string]struct{}{dir: {}}
+	for path, data := range certs {
+		rel, err := filepath.Rel(c.currentDir(), path)
+		if err != nil {
+			return err
+		}
+		path = filepath.Join(dir, rel)
+		err = os.MkdirAll(filepath.Dir(path), 0755)
+		if err != nil {
+			return err
+		}
+		dirs[filepath.Dir(path)] = struct{}{}
+		if isPrivateKey(path) {
+			keyDirs, err := c.keys.write(filepath.Base(dir), rel, path, data)
+			if err != nil {
+				return err
+			}
+			for _, d := range keyDirs {
+				dirs[d] = struct{}{}
+			}
+			klog.Infof("success writing private key to file: %v", path)
+			continue
+		}
+		err = writeFileSync(path, data, 0644)
+		if err != nil {
+			return err
+		}
+		klog.Infof("success writing certificate to file: %v", path)
+	}
+	err = writeFileSync(filepath.Join(dir, configName), config, 0644)
+	if err != nil {
+		return err
+	}
+	for d := range dirs {
+		if err = syncDir(d); err != nil {
+			return err
+		}
+	}
+	return syncDir(filepath.Dir(dir))
+}
+
+// selectGeneration flips the current symlink to the generation and links ngress.conf of conf.d to it
+func (c *Generations) selectGeneration(n int) error {
+	target := filepath.Join(generationsDir, filepath.Base(c.path(n)))
+	err := replaceSymlink(target, c.currentDir())
+	if err != nil {
+		return errors.New(fmt.Sprintf("error: '%v' selecting generation: %v", err, n))
+	}
+	confTarget := filepath.Join(c.currentDir(), configName)
+	confPath := filepath.Join(c.confDir, configName)
+	if link, err := os.Readlink(confPath); err != nil || link != confTarget {
+		err = replaceSymlink(confTarget, confPath)
+		if err != nil {
+			return errors.New(fmt.Sprintf("error: '%v' linking %v", err, confPath))
+		}
+	}
+	return nil
+}
+
+// rollback selects the generation applied before the current one
+func (c *Generations) rollback() error {
+	numbers, err := c.numbers()
+	if err != nil {
+		return err
+	}
+	current := c.current()
+	previous := 0
+	for _, n := range numbers {
+		if n < current {
+			previous = n
+		}
+	}
+	if previous == 0 {
+		return errors.New(fmt.Sprintf("error: no generation before %v", current))
+	}
+	klog.Warningf("rollback to generation %v", previous)
+	return c.selectGeneration(previous)
+}
+
+// cleanup removes generations except the current one and the last kept ones,
+// entries of certsDir written before generations are removed too
+func (c *Generations) cleanup() {
+	numbers, err := c.numbers()
+	if err != nil {
+		klog.Warningf("error: '%v' listing generations", err)
+		return
+	}
+	current := c.current()
+	for i, n := range numbers {
+		if n == current || i >= len(numbers)-1-c.keep {
+			continue
+		}
+		if err = os.RemoveAll(c.path(n)); err != nil {
+			klog.Warningf("error: '%v' removing generation %v", err, n)
+		}
+		if err = c.keys.remove(filepath.Base(c.path(n))); err != nil {
+			klog.Warningf("error: '%v' removing private keys of generation %v", err, n)
+		}
+	}
+
+	entries, err := os.ReadDir(c.dir)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		if entry.Name() == generationsDir || entry.Name() == currentLink {
+			continue
+		}
+		if err = os.RemoveAll(filepath.Join(c.dir, entry.Name())); err != nil {
+			klog.Warningf("error: '%v' removing %v", err, entry.Name())
+		}
+	}
+}
+
+func writeFileSync(path string, data []byte, perm os.FileMode) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(data)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func syncDir(path string) error {
+	dir, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	err = dir.Sync()
+	if closeErr := dir.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// replaceSymlink atomically replaces the link by a symlink to the target
+func replaceSymlink(target string, link string) error {
+	tmp := link + ".tmp"
+	_ = os.Remove(tmp)
+	err := os.Symlink(target, tmp)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(tmp, link)
+	if err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	return syncDir(filepath.Dir(link))
+}
@@ -0,0 +1,89 @@
+package nginx
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// testApply applies the generation of the config and the certificate of the secret ns/tls
+func testApply(t *testing.T, generations *Generations, config string) {
+	certs := map[string][]byte{
+		filepath.Join(generations.currentDir(), "ns", "tls", "tls.crt"): []byte("crt " + config),
+		filepath.Join(generations.currentDir(), "ns", "tls", "tls.key"): []byte("key " + config),
+	}
+	if err := generations.apply([]byte(config), certs); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// testApplied checks the current generation and files seen by nginx through the symlinks
+func testApplied(t *testing.T, generations *Generations, current int, config string) {
+	if n := generations.current(); n != current {
+		t.Errorf("current generation %v, expected %v", n, current)
+	}
+	for path, expected := range map[string]string{
+		filepath.Join(generations.confDir, configName):                  config,
+		filepath.Join(generations.currentDir(), "ns", "tls", "tls.crt"): "crt " + config,
+		filepath.Join(generations.currentDir(), "ns", "tls", "tls.key"): "key " + config,
+	} {
+		data, err := os.ReadFile(path)
+		if err != nil || string(data) != expected {
+			t.Errorf("%v: %q %v, expected %q", path, data, err, expected)
+		}
+	}
+}
+
+func testNumbers(t *testing.T, generations *Generations, expected ...int) {
+	numbers, err := generations.numbers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(numbers, expected) {
+		t.Errorf("generations %v, expected %v", numbers, expected)
+	}
+}
+
+func TestGenerations(t *testing.T) {
+	dir := t.TempDir()
+	certsDir, confDir := filepath.Join(dir, "certs"), filepath.Join(dir, "conf")
+	stale := filepath.Join(certsDir, "ns", "old", "tls.crt")
+	for _, d := range []string{confDir, filepath.Dir(stale)} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// certificates written before generations
+	if err := os.WriteFile(stale, []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	generations := newGenerations(certsDir, confDir, 1, newKeys("", -1, -1))
+	testApply(t, generations, "a")
+	testApplied(t, generations, 1, "a")
+	if _, err := os.Stat(stale); !os.IsNotExist(err) {
+		t.Errorf("entry written before generations not removed: %v", err)
+	}
+
+	testApply(t, generations, "b")
+	testApply(t, generations, "c")
+	testApplied(t, generations, 3, "c")
+	testNumbers(t, generations, 2, 3)
+
+	// the previous generation is selected by the symlink flip
+	if err := generations.rollback(); err != nil {
+		t.Fatal(err)
+	}
+	testApplied(t, generations, 2, "b")
+	if err := generations.rollback(); err == nil {
+		t.Errorf("rollback before the first kept generation succeeded")
+	}
+	testApplied(t, generations, 2, "b")
+
+	// a restarted controller continues numbering, the current generation is kept by cleanup
+	generations = newGenerations(certsDir, confDir, 0, newKeys("", -1, -1))
+	testApply(t, generations, "d")
+	testApplied(t, generations, 4, "d")
+	testNumbers(t, generations, 4)
+}
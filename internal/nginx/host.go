@@ -5,23 +5,44 @@ import (
 	core "k8s.io/api/core/v1"
 	networking "k8s.io/api/networking/v1"
 	"k8s.io/klog/v2"
+	"ngress/internal/conf"
 	"ngress/internal/utils"
-	"strings"
+	"time"
 )
 
+const (
+	skipReasonServiceNotFound = "service_not_found"
+	skipReasonDuplicateRoute  = "duplicate_route"
+)
+
+// RenderStats collected by buildServers for metrics
+type RenderStats struct {
+	routes  int
+	tls     bool
+	skipped map[string]int // reason -> skipped routes
+}
+
 type Host struct {
-	tag           string
-	refCount      int
-	routes        map[string]*Route
-	host          string
-	tlsSecretName string
-	tlsRefCount   int
-	secrets       *Secrets
-	annotations   *Annotations
-	services      map[string]struct{}
+	tag          string
+	routes       map[string]*Route
+	host         string
+	tls          []*HostTLS // candidates in order of attachment, the certificate covering the host wins
+	secrets      *Secrets
+	annotations  *Annotations
+	services     *Services
+	duplicates   int // routes ignored by update
+	stats        RenderStats
+	problems     []*Problem // found by update and attachTLSSecret
+	skipped      []*Problem // found by buildServers
+	defaultRoute *Route     // default backend, location '/' if the host has no catch-all route
+	listenPorts  []uint16   // ports of the default server, the host "" of host-less rules
+	securePorts  []uint16   // secure ports of the default server, TLS servers of other hosts listen them
+	quicPorts    []uint16   // quic ports of the default server, HTTP/3 servers of other hosts listen them
+	aliases      []string   // extra server names
+	redirectFrom string     // other www form of the host redirected to it
 }
 
-func newHost(rule *networking.IngressRule, secrets *Secrets, services map[string]struct{}) *Host {
+func newHost(rule *networking.IngressRule, secrets *Secrets, services *Services) *Host {
 
 	c := &Host{
 		tag:      fmt.Sprintf("HOST:%v", rule.Host),
@@ -35,95 +56,164 @@ func newHost(rule *networking.IngressRule, secrets *Secrets, services map[string
 
 func (c *Host) applyAnnotations(annotations *Annotations) {
 	if c.annotations == nil {
-		c.annotations = annotations
+		a := *annotations // copy, merged annotations of other ingresses must not change the origin
+		c.annotations = &a
 	}
 	c.annotations.merge(annotations)
 }
 
-func (c *Host) attachTLSSecret(secretName string) {
+// HostTLS is a TLS secret of the host declared by an ingress
+type HostTLS struct {
+	secretName string
+	owner      *networking.Ingress
+}
+
+func (c *Host) attachTLSSecret(secretName string, ingress *networking.Ingress) {
 	if len(secretName) == 0 {
 		klog.Errorf("%v> secret name is empty", c.tag)
 		return
 	}
-
-	if len(c.tlsSecretName) == 0 {
-		c.tlsSecretName = secretName
-	} else {
-		if secretName != c.tlsSecretName {
-			klog.Errorf("%v> try to add incorrect secret, expected %v, got %v", c.tag, c.tlsSecretName, secretName)
+	for _, tls := range c.tls {
+		if tls.secretName == secretName {
 			return
 		}
 	}
-	if c.tlsRefCount == 0 {
-		klog.Infof("%v> added TLS secret: %v", c.tag, secretName)
-	}
-
-	c.tlsRefCount++
-	c.tlsSecretName = secretName
+	c.tls = append(c.tls, &HostTLS{secretName: secretName, owner: ingress})
+	klog.Infof("%v> added TLS secret: %v", c.tag, secretName)
 }
 
-func (c *Host) detachTLSSecret(secretName string) {
-	if secretName != c.tlsSecretName {
-		klog.Errorf("%v> try to remove incorrect secret, expected %v, got %v", c.tag, c.tlsSecretName, secretName)
-		return
-	}
-	if c.tlsRefCount > 0 {
-		c.tlsRefCount--
-		if c.tlsRefCount == 0 {
-			c.tlsSecretName = ""
-			klog.Infof("%v> removed TLS secret: %v", c.tag, secretName)
+// selectTLSSecret returns the first valid secret with the certificate covering the host by SANs,
+// the fallback secret if no one is found and valid, nil if there are no secrets or the host falls back to http
+func (c *Host) selectTLSSecret() *Secret {
+	var problem, notFound *Problem
+	for _, tls := range c.tls {
+		secret := c.secrets.get(tls.secretName)
+		if secret == nil {
+			klog.Errorf("%v> SECRET:%v NOT found", c.tag, tls.secretName)
+			missing := &Problem{ingress: tls.owner, reason: reasonSecretNotFound,
+				message: fmt.Sprintf("TLS secret %v of host %v not found", tls.secretName, c.host)}
+			c.skipped = append(c.skipped, missing)
+			if notFound == nil {
+				notFound = missing
+			}
+			continue
+		}
+		err := secret.validate(time.Now())
+		if err == nil && secret.covers(c.host) {
+			c.secrets.keepGood(c.host, secret)
+			return secret
+		}
+		if problem != nil {
+			continue
+		}
+		if err != nil {
+			problem = &Problem{ingress: tls.owner, reason: reasonTLSSecretInvalid, message: err.Error()}
+		} else {
+			problem = &Problem{ingress: tls.owner, reason: reasonTLSSecretMismatch,
+				message: fmt.Sprintf("certificate of TLS secret %v does not cover host %v", secret.name(), c.host)}
 		}
 	}
+	if problem == nil && notFound == nil {
+		return nil
+	}
+	secret, fallback := c.secrets.fallbackFor(c.host)
+	if problem == nil {
+		// the not found secret is already reported
+		notFound.message += fmt.Sprintf(", host %v falls back to %v", c.host, fallback)
+		klog.Warningf("%v> %v", c.tag, notFound.message)
+		return secret
+	}
+	problem.message += fmt.Sprintf(", host %v falls back to %v", c.host, fallback)
+	klog.Warningf("%v> %v", c.tag, problem.message)
+	c.skipped = append(c.skipped, problem)
+	return secret
 }
 
-func (c *Host) addRef() {
-	c.refCount++
-}
-
-func (c *Host) removeRef() bool {
-	if c.refCount > 0 {
-		c.refCount--
+// merge adds routes of the wildcard host not defined by the host itself, routes keep annotations of their
+// owner, TLS secrets and annotations of the host are attached only by ingresses defining it
+func (c *Host) merge(wildcard *Host) {
+	for key, route := range wildcard.routes {
+		if _, ok := c.routes[key]; !ok {
+			r := *route // upstream of the copy depends on the host
+			c.routes[key] = &r
+		}
 	}
-	return c.refCount == 0
 }
 
-func (c *Host) update(namespace string, rule *networking.IngressRule) {
+// update adds routes of the rule, annotations are annotations of the ingress
+func (c *Host) update(ingress *networking.Ingress, annotations *Annotations, rule *networking.IngressRule) {
 	for _, path := range rule.HTTP.Paths {
-		r, ok := c.routes[path.Path]
+		regex := ""
+		if pathType(&path) == networking.PathTypeImplementationSpecific {
+			regex = regexModifier(annotations.regex)
+		}
+		if len(regex) > 0 {
+			if err := validateRegex(path.Path, annotations.rewriteTarget); err != nil {
+				klog.Errorf("%v> regex path %v skipped: %v", c.tag, path.Path, err)
+				c.problems = append(c.problems, &Problem{ingress: ingress, reason: reasonInvalidPath,
+					message: fmt.Sprintf("route %v%v skipped: %v", c.host, path.Path, err)})
+				continue
+			}
+		} else if len(annotations.rewriteTarget) > 0 {
+			c.problems = append(c.problems, &Problem{ingress: ingress, reason: reasonInvalidPath,
+				message: fmt.Sprintf("rewrite target of route %v%v ignored, requires ImplementationSpecific regex path",
+					c.host, path.Path)})
+		}
+
+		key := routeKey(&path, regex)
+		r, ok := c.routes[key]
 		if !ok {
-			route := &Route{path: &path, namespace: namespace}
-			c.routes[path.Path] = route
+			route := newRoute(ingress, &path)
+			route.regex = regex
+			route.upstreamTLS = annotations.upstreamTLS
+			route.websocket = annotations.proto.websocket
+			if len(regex) > 0 {
+				route.rewriteTarget = annotations.rewriteTarget
+			}
+			c.routes[key] = route
 			if len(path.Backend.Service.Port.Name) > 0 {
-				if len(c.annotations.unixSocket) > 0 {
-					route.unixSocket = utils.GetStringValue(path.Backend.Service.Port.Name, c.annotations.unixSocket)
+				if len(annotations.unixSocket) > 0 {
+					route.unixSocket = utils.GetStringValue(path.Backend.Service.Port.Name, annotations.unixSocket)
 				}
-				if len(c.annotations.staticSite) > 0 {
-					route.staticSite = utils.GetStringValue(path.Backend.Service.Port.Name, c.annotations.staticSite)
+				if len(annotations.staticSite) > 0 {
+					route.staticSite = utils.GetStringValue(path.Backend.Service.Port.Name, annotations.staticSite)
 				}
 			}
+			if route.isRouteToService() {
+				route.resolve(c.services)
+			}
 		} else {
 			klog.Errorf("%v> route %v already exist, ignore current", c.tag, r.string())
+			c.duplicates++
+			c.problems = append(c.problems, &Problem{ingress: ingress, reason: reasonDuplicateRoute,
+				message: fmt.Sprintf("route %v%v ignored, already defined by ingress %v",
+					c.host, path.Path, ingressName(r.owner))})
 		}
 	}
 }
 
-func (c *Host) remove(rule *networking.IngressRule) {
-	for _, path := range rule.HTTP.Paths {
-		delete(c.routes, path.Path)
-	}
-}
+func (c *Host) buildServers(opts *RenderOpts) []*conf.Directive {
+	server := newServer(c.host, &c.annotations.proto, opts)
+	server.listenPorts = c.listenPorts
+	server.securePorts = c.securePorts
+	server.quicPorts = c.quicPorts
+	server.aliases = c.aliases
+	server.redirectFrom = c.redirectFrom
+	c.stats = RenderStats{skipped: map[string]int{skipReasonDuplicateRoute: c.duplicates}}
+	c.skipped = nil
 
-func (c *Host) buildServers(certsDir string, sb *strings.Builder) {
-	server := newServer(c.host, &c.annotations.proto)
-
-	secret := c.secrets.get(c.tlsSecretName)
-	if secret == nil {
-		klog.Errorf("%v> SECRET:%v NOT found", c.tag, c.tlsSecretName)
-	} else {
+	secret := c.selectTLSSecret()
+	if len(c.securePorts) > 0 {
+		// unknown SNI gets the default certificate, the handshake is rejected without it
+		secret = c.secrets.defaultSecret()
+		server.rejectHandshake = secret == nil
+	}
+	if secret != nil {
 		klog.Infof("%v> found <SECRET:%v>(%v)", c.tag, secret.name(), secret.string())
-		server.sslCertPath = secret.path(certsDir, core.TLSCertKey)
-		server.sslCertKeyPath = secret.path(certsDir, core.TLSPrivateKeyKey)
+		server.sslCertPath = secret.path(opts.certsDir, core.TLSCertKey)
+		server.sslCertKeyPath = secret.path(opts.certsDir, core.TLSPrivateKeyKey)
 		secret.markForWrite(true)
+		server.redirectHttps = c.checkAliasesTLS(secret)
 	}
 	server.addAltSvc = secret != nil // to prevent add altSvc to 80 port (unsecure)
 
@@ -133,26 +223,78 @@ func (c *Host) buildServers(certsDir string, sb *strings.Builder) {
 	for _, key := range utils.SortedKeys(c.routes) {
 		route := c.routes[key]
 
-		if !haveRootPath && route.path.Path == "/" {
-			haveRootPath = true
-		}
-
 		if route.isRouteToService() {
-			// check for service is exist or not ?
-			_, ok := c.services[route.destination()]
-			if !ok {
-				klog.Warningf("%v> %v service not found, route skipped", c.tag, route.string())
+			// check for service and its port is exist or not ?
+			if route.port == 0 {
+				klog.Warningf("%v> %v service port not found, route skipped", c.tag, route.string())
+				c.stats.skipped[skipReasonServiceNotFound]++
+				c.skipped = append(c.skipped, &Problem{ingress: route.owner, reason: reasonRouteSkipped,
+					message: fmt.Sprintf("route %v%v skipped, service port %v not found",
+						c.host, route.path.Path, route.destination())})
 				continue
 			}
 		}
 
 		klog.Infof("%v> %v", c.tag, route.string())
+		c.setUpstream(route, opts)
 
+		if route.catchAll() {
+			haveRootPath = true
+		}
 		server.addRoute(route)
+		c.stats.routes++
 	}
+	c.stats.tls = server.sslCertPath != "" && server.sslCertKeyPath != ""
 	if !haveRootPath {
+		c.addDefaultRoute(server, opts)
+	}
+
+	directives := server.directives()
+	c.stats.skipped[skipReasonDuplicateRoute] += len(server.duplicates)
+	c.skipped = append(c.skipped, server.duplicates...)
+	return directives
+}
+
+// addDefaultRoute adds location '/' of the default backend, 'return 444' if there is no default backend
+func (c *Host) addDefaultRoute(server *Server, opts *RenderOpts) {
+	if c.defaultRoute == nil {
 		server.addBlockRootLocation()
+		return
 	}
+	if c.defaultRoute.port == 0 {
+		server.addBlockRootLocation()
+		if len(c.host) == 0 { // reported once by the default server
+			klog.Warningf("%v> default backend %v service port not found", c.tag, c.defaultRoute.string())
+			c.skipped = append(c.skipped, &Problem{ingress: c.defaultRoute.owner, reason: reasonRouteSkipped,
+				message: fmt.Sprintf("default backend skipped, service port %v not found", c.defaultRoute.destination())})
+		}
+		return
+	}
+	route := *c.defaultRoute // upstream of the copy depends on the host
+	c.setUpstream(&route, opts)
+	server.addRoute(&route)
+}
+
+// setUpstream sets upstream of the route to service by the render mode
+func (c *Host) setUpstream(route *Route, opts *RenderOpts) {
+	if !route.isRouteToService() || len(route.externalName) > 0 {
+		return
+	}
+	if len(opts.resolver) > 0 {
+		route.upstream = fmt.Sprintf("%v:%v", route.dnsName(), route.dnsTargetPort(opts.endpoints))
+	} else if opts.upstreams != nil {
+		node := ""
+		if c.annotations.preferLocalEndpoints(opts.localEndpoints) {
+			node = opts.node
+		}
+		upstream := newUpstream(route, opts.endpoints, node)
+		opts.upstreams[upstream.name] = upstream
+		route.upstream = upstream.name
+	}
+}
 
-	server.write(sb)
+// report emits events of the problems found during the last build
+func (c *Host) report(events *Events) {
+	events.report(c.problems)
+	events.report(c.skipped)
 }
@@ -4,51 +4,71 @@ import (
 	"fmt"
 	networking "k8s.io/api/networking/v1"
 	"k8s.io/klog/v2"
+	"ngress/internal/utils"
 )
 
 type Ingress struct {
-	tag      string
-	hosts    map[string]*Host
-	secrets  *Secrets
-	hostname string
+	tag         string
+	ingress     *networking.Ingress
+	annotations *Annotations
+	secrets     *Secrets
+	services    *Services
+	applied     bool       // false if the ingress skipped by host affinity or rejected
+	rejected    error      // value of the ingress can not be rendered safely
+	problems    []*Problem // found by the last apply
 }
 
 func newIngress(
 	ingress *networking.Ingress,
-	hosts map[string]*Host,
 	secrets *Secrets,
-	services map[string]struct{},
+	services *Services,
 	hostname string) *Ingress {
 
 	c := &Ingress{
-		tag:      fmt.Sprintf("INGRESS:%s.%s", ingress.Namespace, ingress.Name),
-		hosts:    hosts,
-		secrets:  secrets,
-		hostname: hostname,
+		tag:         fmt.Sprintf("INGRESS:%s.%s", ingress.Namespace, ingress.Name),
+		ingress:     ingress,
+		annotations: newAnnotations(ingress.Annotations),
+		secrets:     secrets,
+		services:    services,
+		applied:     true,
 	}
 
-	annotations := newAnnotations(ingress.Annotations)
-	if len(annotations.hostAffinity) > 0 {
-		if annotations.hostAffinity != c.hostname {
+	if len(c.annotations.hostAffinity) > 0 {
+		if c.annotations.hostAffinity != hostname {
 			klog.Infof("%v > skip %+v, this host: %v != %v ",
-				c.tag, annotations.string(), c.hostname, annotations.hostAffinity)
-			return c
+				c.tag, c.annotations.string(), hostname, c.annotations.hostAffinity)
+			c.applied = false
 		}
 	}
+	if c.rejected = checkIngress(ingress); c.rejected != nil {
+		klog.Errorf("%v rejected: %v", c.tag, c.rejected)
+		c.applied = false
+	}
+	return c
+}
+
+// apply adds rules and TLS secrets of the ingress to the hosts
+func (c *Ingress) apply(hosts map[string]*Host) {
+	if !c.applied {
+		return
+	}
 
+	c.problems = nil
+	ingress := c.ingress
+	own := make(map[string]*Host) // TLS secrets are attached to hosts of the ingress only
 	for _, r := range ingress.Spec.Rules {
-		if r.Host == "" {
-			klog.Warningf("%v skip empty host: %v -> %v", c.tag, r.Host, r.HTTP)
+		if r.HTTP == nil {
 			continue
 		}
-		host, ok := c.hosts[r.Host]
+		// rules without host are routes of the default server, host ""
+		host, ok := hosts[r.Host]
 		if !ok {
-			host = newHost(&r, secrets, services)
-			c.hosts[r.Host] = host
+			host = newHost(&r, c.secrets, c.services)
+			hosts[r.Host] = host
 		}
-		host.applyAnnotations(annotations)
-		host.addRef()
-		host.update(ingress.Namespace, &r)
+		host.applyAnnotations(c.annotations)
+		host.update(ingress, c.annotations, &r)
+		own[r.Host] = host
 	}
 
 	tlsStr := ""
@@ -56,37 +76,26 @@ func newIngress(
 		sn := makeSecretName(ingress.Namespace, tls.SecretName)
 		tlsStr = fmt.Sprintf(", <SECRET:%v> -> %v", sn, tls.Hosts)
 		for _, tlsHost := range tls.Hosts {
-			host, ok := c.hosts[tlsHost]
-			if ok {
-				host.attachTLSSecret(sn)
+			for _, host := range utils.SortedArrayFromMap(own) {
+				if hostMatches(tlsHost, host.host) {
+					host.attachTLSSecret(sn, ingress)
+				}
 			}
 		}
 	}
 
-	klog.Infof("%v create %+v%v", c.tag, annotations.string(), tlsStr)
-	return c
+	klog.Infof("%v create %+v%v", c.tag, c.annotations.string(), tlsStr)
 }
 
-func (c *Ingress) Remove(ingress *networking.Ingress) {
-	klog.Infof("%v remove", c.tag)
-
-	for _, tls := range ingress.Spec.TLS {
-		sn := makeSecretName(ingress.Namespace, tls.SecretName)
-		for _, tlsHost := range tls.Hosts {
-			host, ok := c.hosts[tlsHost]
-			if ok {
-				host.detachTLSSecret(sn)
-			}
-		}
-	}
-
-	for _, r := range ingress.Spec.Rules {
-		host, ok := c.hosts[r.Host]
-		if ok {
-			host.remove(&r)
-			if host.removeRef() {
-				delete(c.hosts, r.Host)
-			}
-		}
+// defaultRoute returns the catch-all route of spec.defaultBackend service, nil if it is not set
+func (c *Ingress) defaultRoute() *Route {
+	backend := c.ingress.Spec.DefaultBackend
+	if !c.applied || backend == nil || backend.Service == nil {
+		return nil
 	}
+	pathType := networking.PathTypePrefix
+	route := newRoute(c.ingress, &networking.HTTPIngressPath{Path: "/", PathType: &pathType, Backend: *backend})
+	route.websocket = c.annotations.proto.websocket
+	route.resolve(c.services)
+	return route
 }
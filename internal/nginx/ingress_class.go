@@ -0,0 +1,61 @@
+package nginx
+
+import (
+	networking "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	listers "k8s.io/client-go/listers/networking/v1"
+)
+
+const legacyIngressClassAnnotation = "kubernetes.io/ingress.class"
+
+type IngressClasses struct {
+	ingressClass   string
+	controllerName string
+	lister         listers.IngressClassLister
+}
+
+func newIngressClasses(ingressClass string, controllerName string, lister listers.IngressClassLister) *IngressClasses {
+	return &IngressClasses{
+		ingressClass:   ingressClass,
+		controllerName: controllerName,
+		lister:         lister,
+	}
+}
+
+func (c *IngressClasses) isOwn(class *networking.IngressClass) bool {
+	return class.Spec.Controller == c.controllerName
+}
+
+func isDefaultIngressClass(class *networking.IngressClass) bool {
+	return class.Annotations[networking.AnnotationIsDefaultIngressClass] == "true"
+}
+
+// haveOwnDefault returns true if one of the default ingress classes belongs to this controller
+func (c *IngressClasses) haveOwnDefault() bool {
+	classes, err := c.lister.List(labels.Everything())
+	if err != nil {
+		return false
+	}
+	for _, class := range classes {
+		if isDefaultIngressClass(class) && c.isOwn(class) {
+			return true
+		}
+	}
+	return false
+}
+
+// accept checks that the ingress must be served by this controller:
+//   - spec.ingressClassName resolves to IngressClass of this controller
+//   - legacy annotation 'kubernetes.io/ingress.class' equals to the -ingress-class flag
+//   - ingress without class is served if the default IngressClass belongs to this controller,
+//     ownDefault is the result of haveOwnDefault computed once for all ingresses
+func (c *IngressClasses) accept(ingress *networking.Ingress, ownDefault bool) bool {
+	if ingress.Spec.IngressClassName != nil && len(*ingress.Spec.IngressClassName) > 0 {
+		class, err := c.lister.Get(*ingress.Spec.IngressClassName)
+		return err == nil && c.isOwn(class)
+	}
+	if className, ok := ingress.Annotations[legacyIngressClassAnnotation]; ok {
+		return className == c.ingressClass
+	}
+	return ownDefault
+}
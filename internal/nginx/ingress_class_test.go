@@ -0,0 +1,58 @@
+package nginx
+
+import (
+	networking "k8s.io/api/networking/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	listers "k8s.io/client-go/listers/networking/v1"
+	"k8s.io/client-go/tools/cache"
+	"testing"
+)
+
+func TestAcceptIngressClass(t *testing.T) {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for name, controller := range map[string]string{"own": "ngress.io/controller", "other": "other.io/controller"} {
+		class := &networking.IngressClass{ObjectMeta: meta.ObjectMeta{Name: name}, Spec: networking.IngressClassSpec{Controller: controller}}
+		if err := indexer.Add(class); err != nil {
+			t.Fatal(err)
+		}
+	}
+	classes := newIngressClasses("ngress", "ngress.io/controller", listers.NewIngressClassLister(indexer))
+	if classes.haveOwnDefault() {
+		t.Errorf("no default class, own default expected false")
+	}
+
+	name := func(class string) *networking.Ingress {
+		return &networking.Ingress{Spec: networking.IngressSpec{IngressClassName: &class}}
+	}
+	legacy := func(class string) *networking.Ingress {
+		return &networking.Ingress{ObjectMeta: meta.ObjectMeta{Annotations: map[string]string{legacyIngressClassAnnotation: class}}}
+	}
+	cases := []struct {
+		name       string
+		ingress    *networking.Ingress
+		ownDefault bool
+		accepted   bool
+	}{
+		{"own class", name("own"), false, true},
+		{"class of other controller", name("other"), true, false},
+		{"unknown class", name("unknown"), true, false},
+		{"legacy annotation of the flag", legacy("ngress"), false, true},
+		{"legacy annotation of other class", legacy("other"), true, false},
+		{"no class, own default", &networking.Ingress{}, true, true},
+		{"no class, no own default", &networking.Ingress{}, false, false},
+	}
+	for _, c := range cases {
+		if accepted := classes.accept(c.ingress, c.ownDefault); accepted != c.accepted {
+			t.Errorf("%v: accepted %v, expected %v", c.name, accepted, c.accepted)
+		}
+	}
+
+	if err := indexer.Update(&networking.IngressClass{ObjectMeta: meta.ObjectMeta{Name: "own",
+		Annotations: map[string]string{networking.AnnotationIsDefaultIngressClass: "true"}},
+		Spec: networking.IngressClassSpec{Controller: "ngress.io/controller"}}); err != nil {
+		t.Fatal(err)
+	}
+	if !classes.haveOwnDefault() {
+		t.Errorf("own class is default, own default expected true")
+	}
+}
@@ -0,0 +1,85 @@
+package nginx
+
+import (
+	core "k8s.io/api/core/v1"
+	"os"
+	"path/filepath"
+)
+
+// Keys are the location and owner of private key files
+type Keys struct {
+	dir string // memory-only directory of private keys, empty - keys are stored with certificates
+	uid int    // owner of private key files, -1 - not changed
+	gid int    // group of private key files, -1 - not changed
+}
+
+func newKeys(dir string, uid int, gid int) *Keys {
+	return &Keys{dir: dir, uid: uid, gid: gid}
+}
+
+func isPrivateKey(path string) bool {
+	return filepath.Base(path) == core.TLSPrivateKeyKey
+}
+
+// path returns the directory of the private keys of the generation, empty if they are stored with certificates
+func (c *Keys) path(generation string) string {
+	if len(c.dir) == 0 {
+		return ""
+	}
+	return filepath.Join(c.dir, generationsDir, generation)
+}
+
+// write writes the private key 0600 to path of the generation, the key is written into the keys directory
+// and linked from path if it is set, returns directories to sync
+func (c *Keys) write(generation string, rel string, path string, data []byte) ([]string, error) {
+	target := path
+	if len(c.dir) > 0 {
+		target = filepath.Join(c.path(generation), rel)
+		if err := c.mkdirAll(filepath.Dir(target)); err != nil {
+			return nil, err
+		}
+	}
+	err := writeFileSync(target, data, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err = c.chown(target); err != nil {
+		return nil, err
+	}
+	if target == path {
+		return nil, nil
+	}
+	return []string{filepath.Dir(target), c.path(generation)}, os.Symlink(target, path)
+}
+
+// mkdirAll creates 0700 directories of keys owned by the key owner
+func (c *Keys) mkdirAll(dir string) error {
+	if _, err := os.Stat(dir); err == nil {
+		return nil
+	}
+	parent := filepath.Dir(dir)
+	if parent != dir {
+		if err := c.mkdirAll(parent); err != nil {
+			return err
+		}
+	}
+	if err := os.Mkdir(dir, 0700); err != nil && !os.IsExist(err) {
+		return err
+	}
+	return c.chown(dir)
+}
+
+func (c *Keys) chown(path string) error {
+	if c.uid < 0 && c.gid < 0 {
+		return nil
+	}
+	return os.Chown(path, c.uid, c.gid)
+}
+
+// remove removes private keys of the generation
+func (c *Keys) remove(generation string) error {
+	if len(c.dir) == 0 {
+		return nil
+	}
+	return os.RemoveAll(c.path(generation))
+}
@@ -0,0 +1,64 @@
+package nginx
+
+import (
+	core "k8s.io/api/core/v1"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func testMode(t *testing.T, path string, mode os.FileMode) {
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != mode {
+		t.Errorf("%v: mode %v, expected %v", path, info.Mode().Perm(), mode)
+	}
+}
+
+func TestPrivateKeyMode(t *testing.T) {
+	dir := t.TempDir()
+	generations := newGenerations(filepath.Join(dir, "certs"), dir, 0, newKeys("", -1, -1))
+	testApply(t, generations, "a")
+	testMode(t, filepath.Join(generations.path(1), "ns", "tls", "tls.key"), 0600)
+	testMode(t, filepath.Join(generations.path(1), "ns", "tls", "tls.crt"), 0644)
+}
+
+func TestPrivateKeysInMemory(t *testing.T) {
+	dir := t.TempDir()
+	keysDir := filepath.Join(dir, "keys")
+	generations := newGenerations(filepath.Join(dir, "certs"), dir, 0, newKeys(keysDir, -1, -1))
+	testApply(t, generations, "a")
+	testApply(t, generations, "b")
+	testApplied(t, generations, 2, "b")
+
+	key := filepath.Join(keysDir, generationsDir, "000002", "ns", "tls", "tls.key")
+	testMode(t, key, 0600)
+	testMode(t, filepath.Dir(key), 0700)
+	if target, err := os.Readlink(filepath.Join(generations.path(2), "ns", "tls", "tls.key")); err != nil || target != key {
+		t.Errorf("key of the generation links to %v %v, expected %v", target, err, key)
+	}
+	if _, err := os.Stat(filepath.Join(keysDir, generationsDir, "000001")); !os.IsNotExist(err) {
+		t.Errorf("keys of the removed generation kept: %v", err)
+	}
+}
+
+func TestSecretKeyMaterial(t *testing.T) {
+	s := testSecret(t, "tls", "a.example.com")
+	s.Data["ca.crt"] = []byte("ca")
+	secret := newSecret(s)
+	secret.markForWrite(true)
+	certs := make(map[string][]byte)
+	secret.fillCerts("/certs", certs)
+	if len(certs) != 2 || certs["/certs/ns/tls/tls.crt"] == nil || certs["/certs/ns/tls/tls.key"] == nil {
+		t.Errorf("written files %v, expected the certificate and the key only", len(certs))
+	}
+
+	// fingerprints only are logged
+	expected := "tls.crt:" + fingerprint(s.Data[core.TLSCertKey]) + ",tls.key:" + fingerprint(s.Data[core.TLSPrivateKeyKey])
+	if description := secret.string(); description != expected || strings.Contains(description, "PRIVATE") {
+		t.Errorf("description %v, expected %v", description, expected)
+	}
+}
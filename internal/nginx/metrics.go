@@ -0,0 +1,133 @@
+package nginx
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"ngress/internal/metrics"
+	"ngress/internal/traffic"
+	"time"
+)
+
+// Metrics of the reconcile and reload pipeline
+type Metrics struct {
+	reconciles        *metrics.CounterVec
+	reconcileDuration *metrics.HistogramVec
+	renders           *metrics.CounterVec
+	configChanges     *metrics.CounterVec
+	certChanges       *metrics.CounterVec
+	validations       *metrics.CounterVec
+	reloads           *metrics.CounterVec
+	reloadFailures    *metrics.CounterVec
+	eventToReload     *metrics.HistogramVec
+	hosts             *metrics.GaugeVec
+	routes            *metrics.GaugeVec
+	tlsSecrets        *metrics.GaugeVec
+	skippedRoutes     *metrics.GaugeVec
+	quarantined       *metrics.GaugeVec
+	ready             *metrics.GaugeVec
+	configHash        *metrics.GaugeVec
+}
+
+func newMetrics(r *metrics.Registry) *Metrics {
+	c := &Metrics{
+		reconciles: r.NewCounterVec("ngress_reconciles_total",
+			"Reconcile loop runs by result.", "result"),
+		reconcileDuration: r.NewHistogramVec("ngress_reconcile_duration_seconds",
+			"Duration of the reconcile: render, validation and apply.", metrics.DefaultBuckets),
+		renders: r.NewCounterVec("ngress_renders_total",
+			"Rendered nginx configs, including staged renders for validation."),
+		configChanges: r.NewCounterVec("ngress_config_changes_total",
+			"Applied changes of ngress.conf."),
+		certChanges: r.NewCounterVec("ngress_cert_changes_total",
+			"Applied changes of the certificates directory."),
+		validations: r.NewCounterVec("ngress_config_validations_total",
+			"Config validations with 'nginx -t' by result.", "result"),
+		reloads: r.NewCounterVec("ngress_reloads_total",
+			"Attempts of nginx reload."),
+		reloadFailures: r.NewCounterVec("ngress_reload_failures_total",
+			"Failed attempts of nginx reload."),
+		eventToReload: r.NewHistogramVec("ngress_event_to_reload_seconds",
+			"Time from the first not applied informer event to the successful nginx reload.", metrics.DefaultBuckets),
+		hosts: r.NewGaugeVec("ngress_hosts",
+			"Hosts in the applied config."),
+		routes: r.NewGaugeVec("ngress_routes",
+			"Routes in the applied config."),
+		tlsSecrets: r.NewGaugeVec("ngress_tls_secrets",
+			"TLS secrets used by the applied config."),
+		skippedRoutes: r.NewGaugeVec("ngress_skipped_routes",
+			"Routes skipped in the applied config by reason.", "reason"),
+		quarantined: r.NewGaugeVec("ngress_quarantined_ingresses",
+			"Ingresses excluded from the config because they break it."),
+		ready: r.NewGaugeVec("ngress_ready",
+			"1 if caches are synced and the first config applied."),
+		configHash: r.NewGaugeVec("ngress_config_hash_info",
+			"Hash of the applied ngress.conf.", "hash"),
+	}
+	for _, counter := range []*metrics.CounterVec{c.renders, c.configChanges, c.certChanges, c.reloads, c.reloadFailures} {
+		counter.Add(0)
+	}
+	c.ready.Set(0)
+	return c
+}
+
+func (c *Metrics) observeReconcile(start time.Time, err error) {
+	c.reconcileDuration.Observe(time.Since(start).Seconds())
+	if err != nil {
+		c.reconciles.Inc("error")
+	} else {
+		c.reconciles.Inc("success")
+	}
+}
+
+func (c *Metrics) observeValidation(err error) {
+	if err != nil {
+		c.validations.Inc("rejected")
+	} else {
+		c.validations.Inc("accepted")
+	}
+}
+
+// observeApplied sets gauges describing the applied config
+func (c *Metrics) observeApplied(config string, hosts map[string]*Host, tlsSecrets int, quarantined int) {
+	routes, named := 0, 0
+	skipped := map[string]int{skipReasonServiceNotFound: 0, skipReasonDuplicateRoute: 0}
+	for _, host := range hosts {
+		if len(host.host) > 0 { // not the default server
+			named++
+		}
+		routes += host.stats.routes
+		for reason, n := range host.stats.skipped {
+			skipped[reason] += n
+		}
+	}
+	c.hosts.Set(float64(named))
+	c.routes.Set(float64(routes))
+	c.tlsSecrets.Set(float64(tlsSecrets))
+	c.skippedRoutes.Reset()
+	for reason, n := range skipped {
+		c.skippedRoutes.Set(float64(n), reason)
+	}
+	c.quarantined.Set(float64(quarantined))
+
+	hash := sha256.Sum256([]byte(config))
+	c.configHash.Reset()
+	c.configHash.Set(1, hex.EncodeToString(hash[:8]))
+}
+
+// trafficRoutes returns label values of the routes of the traffic log, see Route.location
+func trafficRoutes(hosts map[string]*Host) map[traffic.Route]struct{} {
+	routes := make(map[traffic.Route]struct{})
+	for _, host := range hosts {
+		name := host.host
+		if len(name) == 0 {
+			name = "_"
+		}
+		for _, route := range host.routes {
+			routes[traffic.Route{Namespace: route.namespace, Ingress: route.owner.Name, Host: name, Path: route.path.Path}] = struct{}{}
+		}
+		if route := host.defaultRoute; route != nil {
+			routes[traffic.Route{Namespace: route.namespace, Ingress: route.owner.Name, Host: name, Path: route.path.Path}] = struct{}{}
+		}
+	}
+	return routes
+}
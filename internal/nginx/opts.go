@@ -2,6 +2,8 @@ package nginx
 
 import (
 	"flag"
+	"os"
+	"path/filepath"
 	"time"
 )
 
@@ -10,6 +12,30 @@ type Opts struct {
 	certsDir               *string
 	pidFileName            *string
 	reloadDebounceInterval *time.Duration
+	ingressClass           *string
+	controllerName         *string
+	updateStatus           *bool
+	publishStatusAddress   *string
+	statusUpdateInterval   *time.Duration
+	electionID             *string
+	nginxBinary            *string
+	nginxTestDir           *string
+	cacheSyncTimeout       *time.Duration
+	trafficSyslogAddress   *string
+	upstreamEndpoints      *bool
+	upstreamKeepalive      *int
+	preferLocalEndpoints   *bool
+	dnsAddress             *string
+	externalNameResolver   *string
+	externalNameInterval   *time.Duration
+	keepGenerations        *int
+	keysDir                *string
+	keyUID                 *int
+	keyGID                 *int
+	defaultSSLCertificate  *string
+	tlsFallback            *string
+	quarantineRecheck      *time.Duration
+	listenIPv6             *bool
 }
 
 func NewOpts() *Opts {
@@ -20,5 +46,56 @@ func NewOpts() *Opts {
 		reloadDebounceInterval: flag.Duration("nginx-reload-debounce-interval",
 			10*time.Second,
 			"nginx reload debounce interval for prevent very often nginx config reloads on configurations change"),
+		ingressClass: flag.String("ingress-class", "ngress",
+			"ingress class name, used for ingresses with legacy 'kubernetes.io/ingress.class' annotation"),
+		controllerName: flag.String("controller-name", "ngress.org/ingress-controller",
+			"controller name, only ingresses with IngressClass having spec.controller equal to this name are served"),
+		updateStatus: flag.Bool("update-status", true,
+			"publish node addresses into status.loadBalancer of served ingresses, requires POD_NAMESPACE and POD_NAME env"),
+		publishStatusAddress: flag.String("publish-status-address", "",
+			"comma separated IPs or hostnames published into status.loadBalancer instead of node addresses"),
+		statusUpdateInterval: flag.Duration("status-update-interval", 60*time.Second,
+			"interval of ingresses status.loadBalancer update"),
+		electionID: flag.String("election-id", "ngress-leader",
+			"name of the coordination.k8s.io Lease used for status writer leader election"),
+		nginxBinary: flag.String("nginx-binary", "",
+			"path to nginx binary used for 'nginx -t' validation of generated config, empty - validation disabled"),
+		nginxTestDir: flag.String("nginx-test-dir", filepath.Join(os.TempDir(), "ngress-test"),
+			"staging directory for config and certificates validated by 'nginx -t'"),
+		cacheSyncTimeout: flag.Duration("cache-sync-timeout", 2*time.Minute,
+			"timeout of the initial informers cache sync, controller exits if caches are not synced in time"),
+		trafficSyslogAddress: flag.String("traffic-syslog-address", "",
+			"syslog address receiving nginx access log for per route traffic metrics: 'host:port' (UDP) or 'unix:/path', empty - disabled"),
+		upstreamEndpoints: flag.Bool("upstream-endpoints", false,
+			"proxy to ready pod endpoints from EndpointSlices rendered as upstreams instead of service DNS names"),
+		upstreamKeepalive: flag.Int("upstream-keepalive", 32,
+			"idle keepalive connections to the endpoints upstream cached by every nginx worker, 0 - disabled"),
+		preferLocalEndpoints: flag.Bool("prefer-local-endpoints", false,
+			"endpoints on the node of the controller are primary, remote ones are backup, "+
+				"overridden by 'ngress.upstream/local-endpoints' annotation, requires -upstream-endpoints"),
+		dnsAddress: flag.String("dns-address", "",
+			"loopback 'ip:port' of the embedded dns server answering endpoints of services, "+
+				"nginx resolves them at request time instead of reload, requires -upstream-endpoints, empty - disabled"),
+		externalNameResolver: flag.String("external-name-resolver", "",
+			"dns server 'ip[:port]' resolving hostnames of ExternalName services at request time, "+
+				"empty - the first nameserver of /etc/resolv.conf"),
+		externalNameInterval: flag.Duration("external-name-resolve-interval", 30*time.Second,
+			"interval nginx re-resolves hostnames of ExternalName services"),
+		keepGenerations: flag.Int("nginx-keep-generations", 5,
+			"previous generations of the config and certificates kept in <nginx-certs-dir>/generations for inspection and rollback"),
+		keysDir: flag.String("nginx-keys-dir", "",
+			"memory-only directory (tmpfs) of TLS private keys linked from the certs directory, empty - keys are stored with certificates"),
+		keyUID: flag.Int("nginx-key-uid", -1, "owner uid of TLS private key files and directories, -1 - not changed"),
+		keyGID: flag.Int("nginx-key-gid", -1, "owner gid of TLS private key files and directories, -1 - not changed"),
+		defaultSSLCertificate: flag.String("default-ssl-certificate", "",
+			"TLS secret 'namespace/name' of the default certificate served to unknown SNI on secure ports "+
+				"and used by the 'default' and 'last-good' TLS fallbacks, empty - unknown SNI handshake is rejected"),
+		tlsFallback: flag.String("tls-fallback", "last-good",
+			"certificate of a host without valid TLS secret: 'http' - served by http only, "+
+				"'default' - the default certificate, 'last-good' - the last valid certificate of the host or the default one"),
+		quarantineRecheck: flag.Duration("quarantine-recheck-interval", 10*time.Minute,
+			"interval of re-testing quarantined ingresses, they are re-tested on changes of their TLS secrets and services anyway, 0 - disabled"),
+		listenIPv6: flag.Bool("listen-ipv6", true,
+			"servers listen IPv6 addresses '[::]:port' too, disable on nodes without IPv6"),
 	}
 }
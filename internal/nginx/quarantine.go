@@ -0,0 +1,201 @@
+package nginx
+
+import (
+	"fmt"
+	networking "k8s.io/api/networking/v1"
+	"k8s.io/klog/v2"
+	"maps"
+	"ngress/internal/utils"
+	"slices"
+	"time"
+)
+
+// Quarantined is an ingress excluded from the config until it, its TLS secrets or services are changed
+// or the recheck interval is passed
+type Quarantined struct {
+	ingress      *networking.Ingress
+	reason       string
+	dependencies map[string]string // TLS secrets and services of the ingress -> resource version
+	since        time.Time
+}
+
+// activeHosts returns hosts of all ingresses except quarantined ones
+func (c *Controller) activeHosts() map[string]*Host {
+	return c.buildHosts(c.contributing())
+}
+
+// contributing returns sorted names of ingresses contributing to the config, except quarantined ones
+func (c *Controller) contributing() []string {
+	names := make([]string, 0, len(c.ingresses))
+	for _, name := range utils.SortedKeys(c.ingresses) {
+		if _, ok := c.quarantined[name]; !ok && c.ingresses[name].applied {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// buildHosts builds hosts from the given ingresses only, the host "" is the default server
+func (c *Controller) buildHosts(names []string) map[string]*Host {
+	hosts := make(map[string]*Host)
+	var defaultRoute *Route
+	for _, name := range names {
+		ingress := c.ingresses[name]
+		ingress.apply(hosts)
+		route := ingress.defaultRoute()
+		if route == nil {
+			continue
+		}
+		if defaultRoute != nil {
+			msg := fmt.Sprintf("default backend ignored, already defined by ingress %v", ingressName(defaultRoute.owner))
+			klog.Warningf("%v %v", ingress.tag, msg)
+			ingress.problems = append(ingress.problems, &Problem{ingress: ingress.ingress, reason: reasonDefaultBackend, message: msg})
+			continue
+		}
+		defaultRoute = route
+	}
+
+	// exact hosts take precedence, routes of the matching wildcard are added to them
+	for _, wildcard := range utils.SortedArrayFromMap(hosts) {
+		if !isWildcard(wildcard.host) {
+			continue
+		}
+		for _, host := range utils.SortedArrayFromMap(hosts) {
+			if host != wildcard && hostMatches(wildcard.host, host.host) {
+				host.merge(wildcard)
+			}
+		}
+	}
+
+	claimed := make(map[string]string) // alias -> host
+	for _, host := range utils.SortedArrayFromMap(hosts) {
+		host.resolveAliases(hosts, claimed)
+	}
+
+	defaultHost, ok := hosts[""]
+	if !ok {
+		defaultHost = newHost(&networking.IngressRule{}, c.secrets, c.services)
+		defaultHost.applyAnnotations(newAnnotations(nil))
+		hosts[""] = defaultHost
+	}
+	ports := make(map[uint16]struct{})
+	securePorts := make(map[uint16]struct{})
+	quicPorts := make(map[uint16]struct{})
+	for _, host := range hosts {
+		host.defaultRoute = defaultRoute
+		ports[host.annotations.proto.unsecurePort] = struct{}{}
+		if len(host.tls) > 0 {
+			securePorts[host.annotations.proto.securePort] = struct{}{}
+			if host.annotations.proto.http3 {
+				quicPorts[host.annotations.proto.securePort] = struct{}{}
+			}
+		}
+	}
+	defaultHost.listenPorts = slices.Sorted(maps.Keys(ports))
+	defaultHost.securePorts = slices.Sorted(maps.Keys(securePorts))
+	defaultHost.quicPorts = slices.Sorted(maps.Keys(quicPorts))
+	return hosts
+}
+
+func (c *Controller) test(names []string) error {
+	config, certs := c.render(c.buildHosts(names), c.validator.certsDir())
+	err := c.validator.test(config, certs)
+	c.metrics.observeValidation(err)
+	return err
+}
+
+// quarantineBroken bisects contributing ingresses for the first one breaking the config and excludes it.
+// Returns false if the config is broken even without any ingress.
+func (c *Controller) quarantineBroken() bool {
+	names := c.contributing()
+	if len(names) == 0 || c.test(nil) != nil {
+		return false
+	}
+
+	// config of names[:good] accepted by nginx, config of names[:bad] rejected
+	good, bad := 0, len(names)
+	reason := c.validator.State().Error
+	for bad-good > 1 {
+		mid := (good + bad) / 2
+		err := c.test(names[:mid])
+		if err != nil {
+			bad = mid
+			reason = err.Error()
+		} else {
+			good = mid
+		}
+	}
+	c.quarantine(names[bad-1], reason)
+	return true
+}
+
+func (c *Controller) quarantine(name string, reason string) {
+	ingress := c.ingresses[name].ingress
+	c.quarantined[name] = &Quarantined{ingress: ingress, reason: reason,
+		dependencies: c.dependencies(ingress), since: time.Now()}
+	klog.Errorf("INGRESS:%v quarantined, excluded from nginx config: %v", name, reason)
+	c.events.warning(ingress, reasonQuarantined,
+		fmt.Sprintf("excluded from nginx config on host %v: %v", c.hostname, reason))
+	if recheck := *c.opts.quarantineRecheck; recheck > 0 {
+		c.queue.AddAfter(reconcileKey, recheck)
+	}
+}
+
+// dependencies returns resource versions of TLS secrets and services referenced by the ingress, empty if not found
+func (c *Controller) dependencies(ingress *networking.Ingress) map[string]string {
+	dependencies := make(map[string]string)
+	secrets := c.factory.Core().V1().Secrets().Lister().Secrets(ingress.Namespace)
+	for _, tls := range ingress.Spec.TLS {
+		version := ""
+		if secret, err := secrets.Get(tls.SecretName); err == nil {
+			version = secret.ResourceVersion
+		}
+		dependencies[fmt.Sprintf("SECRET:%v/%v", ingress.Namespace, tls.SecretName)] = version
+	}
+
+	services := c.factory.Core().V1().Services().Lister().Services(ingress.Namespace)
+	addService := func(backend *networking.IngressBackend) {
+		if backend == nil || backend.Service == nil {
+			return
+		}
+		version := ""
+		if service, err := services.Get(backend.Service.Name); err == nil {
+			version = service.ResourceVersion
+		}
+		dependencies[fmt.Sprintf("SERVICE:%v/%v", ingress.Namespace, backend.Service.Name)] = version
+	}
+	addService(ingress.Spec.DefaultBackend)
+	for _, rule := range ingress.Spec.Rules {
+		if rule.HTTP == nil {
+			continue
+		}
+		for _, path := range rule.HTTP.Paths {
+			addService(&path.Backend)
+		}
+	}
+	return dependencies
+}
+
+// releaseChanged removes from quarantine updated or removed ingresses, ingresses with changed TLS secrets
+// or services and ingresses quarantined longer than the recheck interval, they are tested again
+func (c *Controller) releaseChanged() {
+	recheck := *c.opts.quarantineRecheck
+	for name, q := range c.quarantined {
+		ing, ok := c.ingresses[name]
+		var why string
+		switch {
+		case !ok:
+			why = "removed"
+		case ingressSpecChanged(q.ingress, ing.ingress):
+			why = "changed"
+		case !maps.Equal(q.dependencies, c.dependencies(ing.ingress)):
+			why = "TLS secrets or services changed"
+		case recheck > 0 && time.Since(q.since) >= recheck:
+			why = "recheck"
+		default:
+			continue
+		}
+		delete(c.quarantined, name)
+		klog.Infof("INGRESS:%v released from quarantine: %v", name, why)
+	}
+}
@@ -0,0 +1,155 @@
+package nginx
+
+import (
+	"fmt"
+	core "k8s.io/api/core/v1"
+	networking "k8s.io/api/networking/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/util/workqueue"
+	"maps"
+	"ngress/internal/metrics"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestReleaseChanged(t *testing.T) {
+	recheck := time.Hour
+	factory := informers.NewSharedInformerFactory(fake.NewSimpleClientset(), 0)
+	secrets := factory.Core().V1().Secrets().Informer().GetIndexer()
+	services := factory.Core().V1().Services().Informer().GetIndexer()
+	c := &Controller{factory: factory, opts: &Opts{quarantineRecheck: &recheck}}
+
+	ingress := testIngress("ing", "a.example.com", "svc")
+	ingress.Spec.TLS = append(ingress.Spec.TLS, networking.IngressTLS{Hosts: []string{"a.example.com"}, SecretName: "tls"})
+	service := testService("svc")
+	service.ResourceVersion = "1"
+	secret := &core.Secret{ObjectMeta: meta.ObjectMeta{Name: "tls", Namespace: "ns", ResourceVersion: "1"}}
+
+	cases := []struct {
+		name     string
+		before   func() // changes before the quarantine
+		change   func()
+		released bool
+	}{
+		{"nothing changed", nil, func() {}, false},
+		{"service changed", nil, func() {
+			changed := service.DeepCopy()
+			changed.ResourceVersion = "2"
+			_ = services.Update(changed)
+		}, true},
+		{"service removed", nil, func() { _ = services.Delete(service) }, true},
+		{"secret changed", nil, func() {
+			changed := secret.DeepCopy()
+			changed.ResourceVersion = "2"
+			_ = secrets.Update(changed)
+		}, true},
+		{"secret created", func() { _ = secrets.Delete(secret) }, func() { _ = secrets.Add(secret) }, true},
+		{"recheck interval passed", nil, func() {
+			c.quarantined["ns.ing"].since = time.Now().Add(-recheck)
+		}, true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			_ = services.Add(service)
+			_ = secrets.Add(secret)
+			if tc.before != nil {
+				tc.before()
+			}
+			c.ingresses = map[string]*Ingress{"ns.ing": {ingress: ingress}}
+			c.quarantined = map[string]*Quarantined{"ns.ing": {ingress: ingress,
+				dependencies: c.dependencies(ingress), since: time.Now()}}
+
+			tc.change()
+			c.releaseChanged()
+			if _, quarantined := c.quarantined["ns.ing"]; quarantined == tc.released {
+				t.Errorf("released %v, expected %v", !quarantined, tc.released)
+			}
+		})
+	}
+}
+
+// testBisectController returns the controller of the ingresses validated by a fake nginx,
+// it rejects config with a host 'broken-*' and any config if baseBroken is true
+func testBisectController(t *testing.T, baseBroken bool, hosts ...string) *Controller {
+	dir := t.TempDir()
+	check := `grep -q 'broken-' "$6/ngress.conf" && { echo "broken host" >&2; exit 1; }`
+	if baseBroken {
+		check = `echo "broken base" >&2; exit 1`
+	}
+	binary := filepath.Join(dir, "nginx")
+	if err := os.WriteFile(binary, []byte("#!/bin/sh\n"+check+"\nexit 0\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	secrets, _ := testSecrets(t, "", fallbackLastGood)
+	services := newServices([]*core.Service{testService("svc")})
+	client := fake.NewSimpleClientset()
+	events := newEvents(client, "node")
+	t.Cleanup(events.stop)
+	c := &Controller{
+		factory:     informers.NewSharedInformerFactory(client, 0),
+		queue:       workqueue.NewTypedRateLimitingQueue(workqueue.DefaultTypedControllerRateLimiter[string]()),
+		ingresses:   make(map[string]*Ingress),
+		quarantined: make(map[string]*Quarantined),
+		secrets:     secrets,
+		services:    services,
+		validator:   newValidator(binary, filepath.Join(dir, "test"), newKeys("", -1, -1)),
+		opts:        testOptions(),
+		events:      events,
+		metrics:     newMetrics(metrics.NewRegistry()),
+		hostname:    "node",
+	}
+	t.Cleanup(c.queue.ShutDown)
+	for i, host := range hosts {
+		ingress := testIngress(fmt.Sprintf("ing-%d", i), host, "svc")
+		c.ingresses[ingressName(ingress)] = newIngress(ingress, secrets, services, c.hostname)
+	}
+	return c
+}
+
+func TestQuarantineBroken(t *testing.T) {
+	cases := []struct {
+		name        string
+		baseBroken  bool
+		hosts       []string
+		quarantined [][]string // quarantined ingresses after every quarantineBroken call returned true
+	}{
+		{"one broken among many", false,
+			[]string{"a.example.com", "b.example.com", "c.example.com", "broken-d.example.com", "e.example.com"},
+			[][]string{{"ns.ing-3"}}},
+		{"first broken", false, []string{"broken-a.example.com", "b.example.com"}, [][]string{{"ns.ing-0"}}},
+		{"last broken", false, []string{"a.example.com", "b.example.com", "broken-c.example.com"}, [][]string{{"ns.ing-2"}}},
+		{"two broken", false,
+			[]string{"a.example.com", "broken-b.example.com", "c.example.com", "broken-d.example.com", "e.example.com"},
+			[][]string{{"ns.ing-1"}, {"ns.ing-1", "ns.ing-3"}}},
+		{"base config broken", true, []string{"a.example.com", "b.example.com"}, nil},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := testBisectController(t, tc.baseBroken, tc.hosts...)
+			for _, expected := range tc.quarantined {
+				if !c.quarantineBroken() {
+					t.Fatalf("nothing quarantined, expected %v", expected)
+				}
+				if quarantined := slices.Sorted(maps.Keys(c.quarantined)); !slices.Equal(quarantined, expected) {
+					t.Fatalf("quarantined %v, expected %v", quarantined, expected)
+				}
+			}
+			if tc.baseBroken {
+				// quarantineBroken is called for a rejected config only
+				if c.quarantineBroken() || len(c.quarantined) > 0 {
+					t.Errorf("quarantined %v with broken base config", slices.Sorted(maps.Keys(c.quarantined)))
+				}
+				return
+			}
+			if err := c.test(c.contributing()); err != nil {
+				t.Errorf("config without quarantined ingresses rejected: %v", err)
+			}
+		})
+	}
+}
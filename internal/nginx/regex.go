@@ -0,0 +1,49 @@
+package nginx
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// regex modes of ImplementationSpecific paths, 'ngress.path/regex' annotation values
+const (
+	regexModeCaseSensitive   = "true"
+	regexModeCaseInsensitive = "case-insensitive"
+)
+
+var captureReference = regexp.MustCompile(`\$([0-9]+)`)
+
+// regexModifier returns nginx location modifier of the regex mode, empty for not regex mode
+func regexModifier(mode string) string {
+	switch mode {
+	case regexModeCaseSensitive:
+		return "~"
+	case regexModeCaseInsensitive:
+		return "~*"
+	default:
+		return ""
+	}
+}
+
+// validateRegex checks the path regex and captures referenced by the rewrite target,
+// regex syntax is checked by RE2, so PCRE only constructs (lookarounds, backreferences) are rejected
+func validateRegex(path string, rewriteTarget string) error {
+	if strings.ContainsAny(path, "\r\n") || strings.ContainsAny(rewriteTarget, "\r\n") {
+		return errors.New("line breaks are not allowed")
+	}
+	re, err := regexp.Compile(path)
+	if err != nil {
+		return errors.New(fmt.Sprintf("error: '%v' invalid regex: %v", err, path))
+	}
+	for _, ref := range captureReference.FindAllStringSubmatch(rewriteTarget, -1) {
+		n, _ := strconv.Atoi(ref[1])
+		if n > re.NumSubexp() {
+			return errors.New(fmt.Sprintf("rewrite target %v references $%v, regex %v has %v capture groups",
+				rewriteTarget, n, path, re.NumSubexp()))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+package nginx
+
+import (
+	core "k8s.io/api/core/v1"
+	networking "k8s.io/api/networking/v1"
+	"ngress/internal/conf"
+	"ngress/internal/utils"
+	"strings"
+	"testing"
+)
+
+func TestValidateRegex(t *testing.T) {
+	cases := []struct {
+		path          string
+		rewriteTarget string
+		valid         bool
+	}{
+		{"/api/v[0-9]+/(.*)", "/$1", true},
+		{"/(a)/(b)", "/$2/$1", true},
+		{"/static/.*", "", true},
+		{"/api/(.*", "", false},
+		{"/(?=a)", "", false},
+		{"/api/(.*)", "/$2", false},
+		{"/api/.*", "/$1", false},
+		{"/api\n/(.*)", "/$1", false},
+		{"/api/(.*)", "/$1\r\n", false},
+	}
+	for _, c := range cases {
+		if err := validateRegex(c.path, c.rewriteTarget); (err == nil) != c.valid {
+			t.Errorf("regex %q rewrite %q: error %v, expected valid %v", c.path, c.rewriteTarget, err, c.valid)
+		}
+	}
+}
+
+// testRegexServer renders the server of the ingress paths with the regex and rewrite-target annotations
+func testRegexServer(t *testing.T, annotations map[string]string, paths ...networking.HTTPIngressPath) (string, *Host) {
+	services := newServices([]*core.Service{testService("svc")})
+	ingress := testIngress("ing", "example.com", "svc")
+	ingress.Annotations = annotations
+	rule := &ingress.Spec.Rules[0]
+	rule.HTTP.Paths = paths
+	a := newAnnotations(annotations)
+	host := newHost(rule, nil, services)
+	host.applyAnnotations(a)
+	host.update(ingress, a, rule)
+
+	server := newServer("example.com", &a.proto, &RenderOpts{})
+	for _, key := range utils.SortedKeys(host.routes) {
+		server.addRoute(host.routes[key])
+	}
+	block := conf.Block("server")
+	server.addLocations(block)
+	var sb strings.Builder
+	if err := conf.Render(&sb, block); err != nil {
+		t.Fatal(err)
+	}
+	return sb.String(), host
+}
+
+func testPath(pathType networking.PathType, path string) networking.HTTPIngressPath {
+	return networking.HTTPIngressPath{Path: path, PathType: &pathType,
+		Backend: networking.IngressBackend{Service: &networking.IngressServiceBackend{
+			Name: "svc", Port: networking.ServiceBackendPort{Number: 80}}}}
+}
+
+func TestRegexLocations(t *testing.T) {
+	config, _ := testRegexServer(t, map[string]string{"ngress.path/regex": "true", "ngress.path/rewrite-target": "/$1"},
+		testPath(networking.PathTypeImplementationSpecific, "/a/(.*)"),
+		testPath(networking.PathTypeImplementationSpecific, "/api/v[0-9]+/(.*)"),
+		testPath(networking.PathTypeExact, "/api"),
+		testPath(networking.PathTypePrefix, "/"))
+	expected := `
+server {
+ location = "/api" {
+  proxy_http_version 1.1;
+  proxy_set_header Host $http_host;
+  proxy_pass "http://svc.ns:80";
+ }
+ location "/" {
+  proxy_http_version 1.1;
+  proxy_set_header Host $http_host;
+  proxy_pass "http://svc.ns:80";
+ }
+ location ~ "/api/v[0-9]+/(.*)" {
+  rewrite "/api/v[0-9]+/(.*)" "/$1" break;
+  proxy_http_version 1.1;
+  proxy_set_header Host $http_host;
+  proxy_pass "http://svc.ns:80";
+ }
+ location ~ "/a/(.*)" {
+  rewrite "/a/(.*)" "/$1" break;
+  proxy_http_version 1.1;
+  proxy_set_header Host $http_host;
+  proxy_pass "http://svc.ns:80";
+ }
+}
+`
+	if config != expected {
+		t.Errorf("rendered\n%v\nexpected\n%v", config, expected)
+	}
+}
+
+func TestRegexCaseInsensitive(t *testing.T) {
+	config, _ := testRegexServer(t, map[string]string{"ngress.path/regex": "case-insensitive", "ngress.path/rewrite-target": "/v2/$1"},
+		testPath(networking.PathTypeImplementationSpecific, "/API/(.*)"))
+	for _, expected := range []string{`location ~* "/API/(.*)" {`, `rewrite "(?i)/API/(.*)" "/v2/$1" break;`} {
+		if !strings.Contains(config, expected) {
+			t.Errorf("no %v in\n%v", expected, config)
+		}
+	}
+}
+
+func TestRegexProblems(t *testing.T) {
+	config, host := testRegexServer(t, map[string]string{"ngress.path/rewrite-target": "/$1"},
+		testPath(networking.PathTypeImplementationSpecific, "/a/(.*)"))
+	if strings.Contains(config, "rewrite") || strings.Contains(config, "~") {
+		t.Errorf("rewrite without regex mode rendered in\n%v", config)
+	}
+	if len(host.problems) != 1 || host.problems[0].reason != reasonInvalidPath {
+		t.Errorf("problems %v, expected ignored rewrite target", host.problems)
+	}
+
+	config, host = testRegexServer(t, map[string]string{"ngress.path/regex": "true", "ngress.path/rewrite-target": "/$2"},
+		testPath(networking.PathTypeImplementationSpecific, "/a/(.*)"),
+		testPath(networking.PathTypeImplementationSpecific, "/b/(?<!x)"))
+	if strings.Contains(config, "location") {
+		t.Errorf("invalid regex paths rendered in\n%v", config)
+	}
+	if len(host.problems) != 2 {
+		t.Errorf("problems %v, expected 2 skipped paths", host.problems)
+	}
+}
@@ -0,0 +1,74 @@
+package nginx
+
+import (
+	"errors"
+	"fmt"
+	networking "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"ngress/internal/conf"
+	"strings"
+)
+
+// annotations with values rendered into nginx config
+var renderedAnnotations = []string{
+	"ngress.static/site",
+	"ngress.unix/socket",
+	"ngress.path/rewrite-target",
+}
+
+// checkIngress returns error if a value of the ingress can not be represented in nginx config safely,
+// the ingress is rejected as a whole, so a part of it is never served
+func checkIngress(ingress *networking.Ingress) error {
+	for _, rule := range ingress.Spec.Rules {
+		if err := checkHost(rule.Host); err != nil {
+			return err
+		}
+		if rule.HTTP == nil {
+			continue
+		}
+		for _, path := range rule.HTTP.Paths {
+			if err := conf.Check(path.Path); err != nil {
+				return errors.New(fmt.Sprintf("error: '%v' in path %q", err, path.Path))
+			}
+			regex := pathType(&path) == networking.PathTypeImplementationSpecific &&
+				len(regexModifier(ingress.Annotations["ngress.path/regex"])) > 0
+			if !regex && !strings.HasPrefix(path.Path, "/") {
+				return errors.New(fmt.Sprintf("path %q is not absolute", path.Path))
+			}
+		}
+	}
+	for _, tls := range ingress.Spec.TLS {
+		for _, host := range tls.Hosts {
+			if err := checkHost(host); err != nil {
+				return err
+			}
+		}
+	}
+	for _, key := range renderedAnnotations {
+		if err := conf.Check(ingress.Annotations[key]); err != nil {
+			return errors.New(fmt.Sprintf("error: '%v' in annotation %v", err, key))
+		}
+	}
+	for _, alias := range strings.Split(ingress.Annotations["ngress.server/aliases"], ",") {
+		alias = strings.TrimSpace(alias)
+		if len(alias) > 0 && len(validation.IsDNS1123Subdomain(strings.ToLower(alias))) > 0 {
+			return errors.New(fmt.Sprintf("alias %q is not a DNS name", alias))
+		}
+	}
+	return nil
+}
+
+// checkHost returns error if the host is not empty and not a DNS name or a wildcard one
+func checkHost(host string) error {
+	if len(host) == 0 {
+		return nil
+	}
+	errs := validation.IsDNS1123Subdomain(host)
+	if isWildcard(host) {
+		errs = validation.IsWildcardDNS1123Subdomain(host)
+	}
+	if len(errs) > 0 {
+		return errors.New(fmt.Sprintf("host %q is not a DNS name: %v", host, strings.Join(errs, ", ")))
+	}
+	return nil
+}
@@ -0,0 +1,20 @@
+package nginx
+
+import "time"
+
+// RenderOpts are options of a single config render
+type RenderOpts struct {
+	certsDir   string
+	ipv6       bool // servers listen IPv6 addresses too
+	trafficLog bool // locations set $ngress_ingress, $ngress_host and $ngress_path variables for the traffic log
+	endpoints  *Endpoints
+	keepalive  int
+	// prefer endpoints of the node by default
+	localEndpoints bool
+	node           string
+	upstreams      map[string]*Upstream // upstreams of the rendered routes, nil - proxy to service DNS names
+	resolver       string               // address of the embedded dns server resolving endpoints, empty - disabled
+	// resolver of ExternalName services, empty - resolved by nginx on reload only
+	externalResolver string
+	externalValid    time.Duration
+}
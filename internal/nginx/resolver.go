@@ -0,0 +1,71 @@
+package nginx
+
+import (
+	"fmt"
+	"net"
+	"ngress/internal/dns"
+	"ngress/internal/utils"
+	"strconv"
+	"strings"
+)
+
+// dnsName is the name of the route service port answered by the embedded dns server
+func (c *Route) dnsName() string {
+	return fmt.Sprintf("%v.%v.%v", c.port, serviceName(c.path.Backend.Service.Name, c.namespace),
+		strings.TrimSuffix(dns.Zone, "."))
+}
+
+// dnsTargetPort returns the port of the endpoints: numeric target port of the service,
+// for named target port the port of the endpoints, the service port if there are no endpoints
+func (c *Route) dnsTargetPort(endpoints *Endpoints) int32 {
+	if c.targetPort != 0 {
+		return c.targetPort
+	}
+	for _, address := range utils.SortedKeys(endpoints.addresses(c.namespace, c.path.Backend.Service.Name, c.portName)) {
+		_, port, err := net.SplitHostPort(address)
+		if err != nil {
+			continue
+		}
+		p, err := strconv.Atoi(port)
+		if err == nil {
+			return int32(p)
+		}
+	}
+	return c.port
+}
+
+// dnsRecords returns records of the routes to services of the hosts and of the default backend,
+// endpoints are taken by port name, so endpoints with other target port are skipped
+func dnsRecords(hosts map[string]*Host, endpoints *Endpoints) map[string]*dns.Records {
+	records := make(map[string]*dns.Records)
+	for _, host := range hosts {
+		for _, route := range host.routes {
+			addRecord(records, route, endpoints)
+		}
+	}
+	// the default backend is shared by all hosts
+	if host, ok := hosts[""]; ok && host.defaultRoute != nil {
+		addRecord(records, host.defaultRoute, endpoints)
+	}
+	return records
+}
+
+func addRecord(records map[string]*dns.Records, route *Route, endpoints *Endpoints) {
+	if !route.isRouteToService() || route.port == 0 || len(route.externalName) > 0 {
+		return
+	}
+	name := strings.ToLower(route.dnsName()) + "."
+	if _, ok := records[name]; ok {
+		return
+	}
+	port := route.dnsTargetPort(endpoints)
+	r := &dns.Records{Port: uint16(port)}
+	for _, address := range utils.SortedKeys(endpoints.addresses(route.namespace, route.path.Backend.Service.Name, route.portName)) {
+		ip, p, err := net.SplitHostPort(address)
+		if err != nil || p != strconv.Itoa(int(port)) {
+			continue
+		}
+		r.IPs = append(r.IPs, net.ParseIP(ip))
+	}
+	records[name] = r
+}
@@ -0,0 +1,63 @@
+package nginx
+
+import (
+	core "k8s.io/api/core/v1"
+	discovery "k8s.io/api/discovery/v1"
+	networking "k8s.io/api/networking/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"testing"
+)
+
+func testService(name string) *core.Service {
+	return &core.Service{
+		ObjectMeta: meta.ObjectMeta{Name: name, Namespace: "ns"},
+		Spec: core.ServiceSpec{Ports: []core.ServicePort{
+			{Name: "http", Port: 80, TargetPort: intstr.FromInt32(8080)}}},
+	}
+}
+
+func testSlice(service string, ip string) *discovery.EndpointSlice {
+	name, port := "http", int32(8080)
+	return &discovery.EndpointSlice{
+		ObjectMeta:  meta.ObjectMeta{Name: service, Namespace: "ns", Labels: map[string]string{discovery.LabelServiceName: service}},
+		AddressType: discovery.AddressTypeIPv4,
+		Endpoints:   []discovery.Endpoint{{Addresses: []string{ip}}},
+		Ports:       []discovery.EndpointPort{{Name: &name, Port: &port}},
+	}
+}
+
+// testRoute makes a route of the ingress ns/ing to the service port 80
+func testRoute(services *Services, pathType networking.PathType, path string, service string) *Route {
+	ingress := &networking.Ingress{ObjectMeta: meta.ObjectMeta{Name: "ing", Namespace: "ns"}}
+	route := newRoute(ingress, &networking.HTTPIngressPath{Path: path, PathType: &pathType,
+		Backend: networking.IngressBackend{Service: &networking.IngressServiceBackend{
+			Name: service, Port: networking.ServiceBackendPort{Number: 80}}}})
+	route.resolve(services)
+	return route
+}
+
+func TestDnsRecordsDefaultBackend(t *testing.T) {
+	services := newServices([]*core.Service{testService("web"), testService("def")})
+	endpoints := newEndpoints([]*discovery.EndpointSlice{testSlice("web", "10.0.0.1"), testSlice("def", "10.0.0.2")})
+	defaultRoute := testRoute(services, networking.PathTypePrefix, "/", "def")
+	hosts := map[string]*Host{
+		"":        {routes: map[string]*Route{}, defaultRoute: defaultRoute},
+		"web.com": {host: "web.com", routes: map[string]*Route{"/": testRoute(services, networking.PathTypePrefix, "/", "web")}, defaultRoute: defaultRoute},
+	}
+
+	records := dnsRecords(hosts, endpoints)
+
+	for name, ip := range map[string]string{"80.web.ns.ngress.local.": "10.0.0.1", "80.def.ns.ngress.local.": "10.0.0.2"} {
+		r, ok := records[name]
+		if !ok {
+			t.Fatalf("no record of %v, records: %v", name, records)
+		}
+		if r.Port != 8080 || len(r.IPs) != 1 || r.IPs[0].String() != ip {
+			t.Errorf("record of %v: port %v ips %v, expected 8080 [%v]", name, r.Port, r.IPs, ip)
+		}
+	}
+	if len(records) != 2 {
+		t.Errorf("expected 2 records, got %v", len(records))
+	}
+}
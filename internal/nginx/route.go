@@ -3,14 +3,49 @@ package nginx
 import (
 	"fmt"
 	networking "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"ngress/internal/conf"
+	"ngress/internal/dns"
 	"strings"
 )
 
 type Route struct {
 	namespace  string
+	owner      *networking.Ingress
 	path       *networking.HTTPIngressPath
 	unixSocket string // if not empty -> use unix socket
 	staticSite string // if not empty -> use unix socket
+	port       int32  // resolved port of the service, 0 if the service or its port not found
+	portName   string // name of the resolved port, endpoints ports are named by it
+	upstream   string // name of the endpoints upstream or 'name:port' resolved by dns, set by render
+	targetPort int32  // numeric target port of the service, 0 if it is named
+	regex      string // '~' or '~*' for regex path, empty otherwise
+	// replacement of the regex path rewrite, may reference captures
+	rewriteTarget string
+	externalName  string // hostname of ExternalName service, proxied directly
+	upstreamTLS   bool   // proxy to ExternalName service by https
+	websocket     bool   // websocket annotation of the owner ingress
+}
+
+func newRoute(ingress *networking.Ingress, path *networking.HTTPIngressPath) *Route {
+	return &Route{path: path, namespace: ingress.Namespace, owner: ingress}
+}
+
+// resolve resolves the backend port of the route to service
+func (c *Route) resolve(services *Services) {
+	port := services.port(c.namespace, c.path.Backend.Service)
+	if port == nil {
+		return
+	}
+	c.port = port.Port
+	c.portName = port.Name
+	c.externalName = services.externalName(c.namespace, c.path.Backend.Service)
+	c.targetPort = port.Port
+	if port.TargetPort.Type == intstr.String {
+		c.targetPort = 0 // named, resolved by endpoints
+	} else if port.TargetPort.IntVal != 0 {
+		c.targetPort = port.TargetPort.IntVal
+	}
 }
 
 func (c *Route) destination() string {
@@ -20,10 +55,21 @@ func (c *Route) destination() string {
 	if len(c.unixSocket) > 0 {
 		return fmt.Sprintf("unix:%v", c.unixSocket)
 	}
-	return fmt.Sprintf("%v.%v:%v",
-		c.path.Backend.Service.Name,
-		c.namespace,
-		c.path.Backend.Service.Port.Number)
+	if len(c.externalName) > 0 {
+		return fmt.Sprintf("%v:%v", c.externalName, c.port)
+	}
+	return fmt.Sprintf("%v:%v", serviceName(c.path.Backend.Service.Name, c.namespace), c.servicePort())
+}
+
+// servicePort returns the resolved port, the port of the backend if it is not resolved
+func (c *Route) servicePort() string {
+	if c.port != 0 {
+		return fmt.Sprint(c.port)
+	}
+	if len(c.path.Backend.Service.Port.Name) > 0 {
+		return c.path.Backend.Service.Port.Name
+	}
+	return fmt.Sprint(c.path.Backend.Service.Port.Number)
 }
 
 func (c *Route) isRouteToService() bool {
@@ -41,89 +87,172 @@ func (c *Route) upstreamName() string {
 	return fmt.Sprintf("%v_%v_%v",
 		c.path.Backend.Service.Name,
 		c.namespace,
-		c.path.Backend.Service.Port.Number)
+		c.servicePort())
 }
 
-func (c *Route) location() string {
-	p := c.path
-	var s strings.Builder
-	if *p.PathType == networking.PathTypeExact {
-		s.WriteString("= ")
+func pathType(path *networking.HTTPIngressPath) networking.PathType {
+	if path.PathType == nil {
+		return networking.PathTypeImplementationSpecific
 	}
-	s.WriteString(p.Path)
-	return s.String()
+	return *path.PathType
 }
 
-func (c *Route) string() string {
-	return fmt.Sprintf("'%v' => '%v'", c.location(), c.destination())
+// routeKey identifies the route of the host by path type, regex modifier and path,
+// trailing slash of Prefix path is not significant: '/foo/' is the same as '/foo'
+func routeKey(path *networking.HTTPIngressPath, regex string) string {
+	p := path.Path
+	if pathType(path) == networking.PathTypePrefix {
+		p = prefixPath(p)
+	}
+	if len(regex) > 0 {
+		p = regex + " " + p
+	}
+	return fmt.Sprintf("%v %v", pathType(path), p)
 }
 
-func (c *Route) write(addAltSvc bool, opts *ProtoOpts, sb *strings.Builder) {
-	locationPrefix := ""
-	if *c.path.PathType == networking.PathTypeExact {
-		locationPrefix = "="
+// prefixPath returns Prefix path without trailing slashes, '/' for the root
+func prefixPath(path string) string {
+	p := strings.TrimRight(path, "/")
+	if len(p) == 0 {
+		return "/"
 	}
-	sb.WriteString(fmt.Sprintf(
-		`
- location %s %s {`,
-		locationPrefix, c.path.Path))
+	return p
+}
 
-	if len(c.staticSite) > 0 {
-		sb.WriteString(fmt.Sprintf(
-			`
-  root %v;
-  try_files $uri $uri/ /index.html;`,
-			c.staticSite))
-	} else {
-		sb.WriteString(fmt.Sprintf(
-			`
-  proxy_http_version 1.1;
-  proxy_set_header Host $http_host;
-  proxy_pass http://%v;`,
-			c.destination()))
+// Location is nginx location of the route: modifier '=', '~', '~*' or empty for prefix location, and path
+type Location struct {
+	modifier string
+	path     string
+}
+
+func (c Location) string() string {
+	if len(c.modifier) == 0 {
+		return c.path
 	}
+	return c.modifier + " " + c.path
+}
 
-	if opts.websocket {
-		sb.WriteString(`
-  proxy_set_header Upgrade $http_upgrade;
-  proxy_set_header Connection "upgrade";`)
+// locations returns nginx locations of the route:
+//   - Exact '/foo': '= /foo'
+//   - Prefix '/foo' or '/foo/': '= /foo' and '/foo/', matches '/foo', '/foo/' and '/foo/bar' but not '/foobar'
+//     as the spec requires element-wise match, Prefix '/' is '/'
+//   - ImplementationSpecific '/foo': nginx prefix location '/foo', matches '/foo', '/foo/bar' and '/foobar'
+//   - ImplementationSpecific regex: '~ regex' or '~* regex', nginx checks them after '=' locations
+//     and before prefix ones, in the order they are written
+func (c *Route) locations() []Location {
+	if len(c.regex) > 0 {
+		return []Location{{modifier: c.regex, path: c.path.Path}}
 	}
+	switch pathType(c.path) {
+	case networking.PathTypeExact:
+		return []Location{{modifier: "=", path: c.path.Path}}
+	case networking.PathTypePrefix:
+		p := prefixPath(c.path.Path)
+		if p == "/" {
+			return []Location{{path: p}}
+		}
+		return []Location{{modifier: "=", path: p}, {path: p + "/"}}
+	default:
+		return []Location{{path: c.path.Path}}
+	}
+}
 
-	if addAltSvc {
-		c.addAltSvcHeaderIfNeeded(opts, sb)
+// catchAll is true if the route matches any path
+func (c *Route) catchAll() bool {
+	locations := c.locations()
+	return len(locations) == 1 && locations[0] == Location{path: "/"}
+}
+
+func (c *Route) string() string {
+	locations := make([]string, 0, 2)
+	for _, location := range c.locations() {
+		locations = append(locations, location.string())
 	}
-	sb.WriteString("\n }\n")
+	return fmt.Sprintf("'%v' => '%v'", strings.Join(locations, "' '"), c.destination())
 }
 
-func (c *Route) addAltSvcHeaderIfNeeded(opts *ProtoOpts, sb *strings.Builder) {
-	http := func(n int, port uint16) string {
-		return fmt.Sprintf("h%d=\":%v\"", n, port)
+// location returns the location block of the route, all values of the ingress are quoted
+func (c *Route) location(location Location, host string, addAltSvc bool, opts *ProtoOpts, render *RenderOpts) *conf.Directive {
+	var block *conf.Directive
+	if len(location.modifier) > 0 {
+		block = conf.Block("location", location.modifier).Literal(location.path)
+	} else {
+		block = conf.Block("location").Literal(location.path)
 	}
 
-	if opts.http2 || opts.http3 {
-		sb.WriteString("\n  add_header Alt-Svc ")
+	if len(c.rewriteTarget) > 0 {
+		// rewrite matches the path regex again, its captures are referenced by the target
+		regex := c.path.Path
+		if c.regex == "~*" {
+			regex = "(?i)" + regex
+		}
+		block.Add(conf.New("rewrite").Literal(regex).Template(c.rewriteTarget).Words("break"))
 	}
 
-	if opts.http2 {
-		sb.WriteString(http(2, opts.securePort))
+	if render.trafficLog {
+		block.Add(
+			conf.New("set", "$ngress_ingress").Value(c.namespace+"/"+c.owner.Name),
+			conf.New("set", "$ngress_host").Value(host),
+			conf.New("set", "$ngress_path").Value(c.path.Path))
 	}
 
-	if opts.http3 {
-		if opts.http2 {
-			sb.WriteString(",")
+	if len(c.staticSite) > 0 {
+		block.Add(
+			conf.New("root").Value(c.staticSite),
+			conf.New("try_files", "$uri", "$uri/", "/index.html"))
+	} else if len(c.externalName) > 0 {
+		block.Add(c.externalNameProxy(render)...)
+	} else if len(render.resolver) > 0 {
+		// resolved by the embedded dns server at request time, endpoints changes do not need reload
+		block.Add(
+			conf.New("proxy_http_version", "1.1"),
+			conf.New("proxy_set_header", "Host", "$http_host"),
+			conf.New("resolver", render.resolver, fmt.Sprintf("valid=%vs", dns.TTL)),
+			conf.New("set", "$ngress_backend").Value(c.upstream),
+			conf.New("proxy_pass", "http://$ngress_backend"))
+	} else if render.upstreams != nil {
+		block.Add(
+			conf.New("proxy_http_version", "1.1"),
+			conf.New("proxy_set_header", "Host", "$http_host"),
+			conf.New("proxy_pass").Value("http://"+c.upstream))
+		if !c.websocket && render.keepalive > 0 {
+			// keep upstream connections alive
+			block.Add(conf.New("proxy_set_header", "Connection").Literal(""))
 		}
-		sb.WriteString(http(3, opts.securePort))
+	} else {
+		block.Add(
+			conf.New("proxy_http_version", "1.1"),
+			conf.New("proxy_set_header", "Host", "$http_host"),
+			conf.New("proxy_pass").Value("http://"+c.destination()))
+	}
+
+	if c.websocket {
+		block.Add(
+			conf.New("proxy_set_header", "Upgrade", "$http_upgrade"),
+			conf.New("proxy_set_header", "Connection").Literal("upgrade"))
 	}
 
-	if opts.http2 || opts.http3 {
-		sb.WriteString(";")
+	if addAltSvc {
+		block.Add(altSvcHeader(opts))
+	}
+	return block
+}
+
+// altSvcHeader returns Alt-Svc header of http2 and http3 on the secure port, nil if both are disabled
+func altSvcHeader(opts *ProtoOpts) *conf.Directive {
+	var protocols []string
+	if opts.http2 {
+		protocols = append(protocols, fmt.Sprintf("h2=\":%v\"", opts.securePort))
+	}
+	if opts.http3 {
+		protocols = append(protocols, fmt.Sprintf("h3=\":%v\"", opts.securePort))
+	}
+	if len(protocols) == 0 {
+		return nil
 	}
+	return conf.New("add_header", "Alt-Svc").Literal(strings.Join(protocols, ","))
 }
 
-func locationRoot444(sb *strings.Builder) {
-	sb.WriteString(`
- location / {
-   return 444;
- }
-`)
+func locationRoot444() *conf.Directive {
+	return conf.Block("location", "/").Add(conf.New("return", "444"))
 }
@@ -0,0 +1,186 @@
+package nginx
+
+import (
+	core "k8s.io/api/core/v1"
+	networking "k8s.io/api/networking/v1"
+	"ngress/internal/conf"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+var testLocation = regexp.MustCompile(`(?m)^ location (= )?"([^"]*)" \{\n  proxy_http_version 1.1;\n  proxy_set_header Host \$http_host;\n  proxy_pass "http://([^.]*)\.`)
+
+// testMatch renders the server of the routes and returns the service of the request path
+// as nginx selects the location: exact location first, then the longest prefix one, empty if nothing matches
+func testMatch(t *testing.T, routes []*Route, request string) string {
+	server := newServer("example.com", &ProtoOpts{unsecurePort: 80, securePort: 443}, &RenderOpts{})
+	for _, route := range routes {
+		server.addRoute(route)
+	}
+	var sb strings.Builder
+	if err := conf.Render(&sb, server.directives()...); err != nil {
+		t.Fatal(err)
+	}
+	service, longest := "", -1
+	for _, m := range testLocation.FindAllStringSubmatch(sb.String(), -1) {
+		exact, path, backend := m[1] != "", m[2], m[3]
+		if exact && path == request {
+			return backend
+		}
+		if !exact && strings.HasPrefix(request, path) && len(path) > longest {
+			service, longest = backend, len(path)
+		}
+	}
+	return service
+}
+
+// cases of the ingress conformance tests: path_rules, exact and prefix matching
+func TestLocations(t *testing.T) {
+	services := newServices([]*core.Service{testService("exact"), testService("prefix"), testService("root")})
+	exact := func(path string) *Route {
+		return testRoute(services, networking.PathTypeExact, path, "exact")
+	}
+	prefix := func(path string) *Route {
+		return testRoute(services, networking.PathTypePrefix, path, "prefix")
+	}
+	root := testRoute(services, networking.PathTypePrefix, "/", "root")
+
+	cases := []struct {
+		name    string
+		routes  []*Route
+		request string
+		service string
+	}{
+		{"exact /foo matches /foo", []*Route{exact("/foo")}, "/foo", "exact"},
+		{"exact /foo does not match /foo/", []*Route{exact("/foo")}, "/foo/", ""},
+		{"exact /foo does not match /foo/bar", []*Route{exact("/foo")}, "/foo/bar", ""},
+		{"exact /foo does not match /foobar", []*Route{exact("/foo")}, "/foobar", ""},
+		{"prefix /foo matches /foo", []*Route{prefix("/foo")}, "/foo", "prefix"},
+		{"prefix /foo matches /foo/", []*Route{prefix("/foo")}, "/foo/", "prefix"},
+		{"prefix /foo matches /foo/bar", []*Route{prefix("/foo")}, "/foo/bar", "prefix"},
+		{"prefix /foo does not match /foobar", []*Route{prefix("/foo")}, "/foobar", ""},
+		{"prefix /foo/ matches /foo", []*Route{prefix("/foo/")}, "/foo", "prefix"},
+		{"prefix /foo/ matches /foo/bar", []*Route{prefix("/foo/")}, "/foo/bar", "prefix"},
+		{"prefix /foo/ does not match /foobar", []*Route{prefix("/foo/")}, "/foobar", ""},
+		{"prefix /aaa/bbb does not match /aaa/bbbxyz", []*Route{prefix("/aaa/bbb")}, "/aaa/bbbxyz", ""},
+		{"exact takes precedence over prefix", []*Route{exact("/foo"), prefix("/foo")}, "/foo", "exact"},
+		{"prefix of exact path matches subpaths", []*Route{exact("/foo"), prefix("/foo")}, "/foo/bar", "prefix"},
+		{"longest prefix wins", []*Route{prefix("/foo"), testRoute(services, networking.PathTypePrefix, "/foo/bar", "exact")}, "/foo/bar/baz", "exact"},
+		{"prefix / matches /", []*Route{root}, "/", "root"},
+		{"prefix / matches any path", []*Route{root}, "/foobar", "root"},
+		{"prefix / is the fallback of other prefixes", []*Route{root, prefix("/foo")}, "/foobar", "root"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if service := testMatch(t, c.routes, c.request); service != c.service {
+				t.Errorf("request %v routed to %q, expected %q", c.request, service, c.service)
+			}
+		})
+	}
+}
+
+func TestLocationsOfPathTypes(t *testing.T) {
+	services := newServices(nil)
+	cases := []struct {
+		pathType  networking.PathType
+		path      string
+		locations []Location
+	}{
+		{networking.PathTypeExact, "/foo", []Location{{modifier: "=", path: "/foo"}}},
+		{networking.PathTypeExact, "/foo/", []Location{{modifier: "=", path: "/foo/"}}},
+		{networking.PathTypePrefix, "/foo", []Location{{modifier: "=", path: "/foo"}, {path: "/foo/"}}},
+		{networking.PathTypePrefix, "/foo/", []Location{{modifier: "=", path: "/foo"}, {path: "/foo/"}}},
+		{networking.PathTypePrefix, "/", []Location{{path: "/"}}},
+		{networking.PathTypeImplementationSpecific, "/foo", []Location{{path: "/foo"}}},
+	}
+	for _, c := range cases {
+		locations := testRoute(services, c.pathType, c.path, "svc").locations()
+		if len(locations) != len(c.locations) {
+			t.Errorf("%v %v: locations %v, expected %v", c.pathType, c.path, locations, c.locations)
+			continue
+		}
+		for i := range locations {
+			if locations[i] != c.locations[i] {
+				t.Errorf("%v %v: locations %v, expected %v", c.pathType, c.path, locations, c.locations)
+			}
+		}
+	}
+}
+
+func TestDuplicateLocations(t *testing.T) {
+	services := newServices([]*core.Service{testService("a"), testService("b")})
+	cases := []struct {
+		name       string
+		routes     []*Route
+		duplicates int
+	}{
+		{"exact overrides exact match of prefix", []*Route{
+			testRoute(services, networking.PathTypeExact, "/foo", "a"),
+			testRoute(services, networking.PathTypePrefix, "/foo", "b")}, 0},
+		{"prefix location of implementation specific path", []*Route{
+			testRoute(services, networking.PathTypeImplementationSpecific, "/foo/", "a"),
+			testRoute(services, networking.PathTypePrefix, "/foo", "b")}, 1},
+		{"root of implementation specific path", []*Route{
+			testRoute(services, networking.PathTypeImplementationSpecific, "/", "a"),
+			testRoute(services, networking.PathTypePrefix, "/", "b")}, 1},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			server := newServer("example.com", &ProtoOpts{unsecurePort: 80}, &RenderOpts{})
+			for _, route := range c.routes {
+				server.addRoute(route)
+			}
+			server.directives()
+			if len(server.duplicates) != c.duplicates {
+				t.Errorf("duplicates %v, expected %v", len(server.duplicates), c.duplicates)
+			}
+			for _, problem := range server.duplicates {
+				if problem.reason != reasonDuplicateRoute {
+					t.Errorf("reason %v, expected %v", problem.reason, reasonDuplicateRoute)
+				}
+			}
+		})
+	}
+}
+
+func TestTrafficLogVariables(t *testing.T) {
+	services := newServices([]*core.Service{testService("svc")})
+	for _, name := range []string{"example.com", "*.example.com", ""} {
+		server := newServer(name, &ProtoOpts{unsecurePort: 80}, &RenderOpts{trafficLog: true})
+		server.addRoute(testRoute(services, networking.PathTypeExact, "/foo", "svc"))
+		block := conf.Block("server")
+		server.addLocations(block)
+		var sb strings.Builder
+		if err := conf.Render(&sb, block); err != nil {
+			t.Fatal(err)
+		}
+		host := name
+		if len(host) == 0 {
+			host = "_"
+		}
+		for _, expected := range []string{`set $ngress_ingress "ns/ing";`, `set $ngress_host "` + host + `";`, `set $ngress_path "/foo";`} {
+			if !strings.Contains(sb.String(), expected) {
+				t.Errorf("%q: no %v in\n%v", name, expected, sb.String())
+			}
+		}
+	}
+}
+
+func TestListenIPv6(t *testing.T) {
+	for _, ipv6 := range []bool{true, false} {
+		server := newServer("", &ProtoOpts{unsecurePort: 80, securePort: 443}, &RenderOpts{ipv6: ipv6})
+		server.listenPorts = []uint16{80}
+		server.securePorts = []uint16{443}
+		server.rejectHandshake = true
+		var sb strings.Builder
+		if err := conf.Render(&sb, server.directives()...); err != nil {
+			t.Fatal(err)
+		}
+		for _, listen := range []string{"listen [::]:80 default_server;", "listen [::]:443 ssl default_server;"} {
+			if strings.Contains(sb.String(), listen) != ipv6 {
+				t.Errorf("ipv6 %v: %v expected %v in\n%v", ipv6, listen, ipv6, sb.String())
+			}
+		}
+	}
+}
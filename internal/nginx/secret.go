@@ -1,16 +1,52 @@
 package nginx
 
 import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
 	"fmt"
-	"hash/adler32"
 	core "k8s.io/api/core/v1"
 	"k8s.io/klog/v2"
 	"path/filepath"
+	"strings"
+	"time"
 )
 
 type Secret struct {
 	secret    *core.Secret
 	mustWrite bool
+	dir       string       // directory of the files relative to certsDir, the secret name if empty
+	parsed    *certificate // parsed on first use, shared by renders of the same resource version
+}
+
+// certificate is the parsed certificate of the TLS secret, err is set if the certificate or the key is invalid
+type certificate struct {
+	resourceVersion string
+	cert            *x509.Certificate
+	err             error
+}
+
+// parseCertificate checks PEM of the certificate and the key, the key matches the certificate
+func parseCertificate(secret *core.Secret) *certificate {
+	name := makeSecretName(secret.Namespace, secret.Name)
+	parsed := &certificate{resourceVersion: secret.ResourceVersion}
+	certPEM, keyPEM := secret.Data[core.TLSCertKey], secret.Data[core.TLSPrivateKeyKey]
+	if len(certPEM) == 0 || len(keyPEM) == 0 {
+		parsed.err = errors.New(fmt.Sprintf("TLS secret %v has no %v or %v", name, core.TLSCertKey, core.TLSPrivateKeyKey))
+		return parsed
+	}
+	pair, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		parsed.err = errors.New(fmt.Sprintf("error: '%v' in TLS secret %v", err, name))
+		return parsed
+	}
+	parsed.cert, err = x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		parsed.err = errors.New(fmt.Sprintf("error: '%v' parsing certificate of TLS secret %v", err, name))
+	}
+	return parsed
 }
 
 func newSecret(secret *core.Secret) *Secret {
@@ -31,6 +67,9 @@ func (c *Secret) path(certsDir string, name string) string {
 		klog.Errorf("SECRET:%v file:%v NOT found", c.name(), name)
 		return ""
 	}
+	if len(c.dir) > 0 {
+		return filepath.Join(certsDir, c.dir, name)
+	}
 	return filepath.Join(certsDir, c.name(), name)
 }
 
@@ -38,17 +77,30 @@ func (c *Secret) data(name string) []byte {
 	return c.secret.Data[name]
 }
 
+// string returns sha256 fingerprints of the certificate and the key, other data is not shown
 func (c *Secret) string() string {
 	data := ""
-	for k, v := range c.secret.Data {
+	for _, k := range []string{core.TLSCertKey, core.TLSPrivateKeyKey} {
 		if len(data) > 0 {
 			data += ","
 		}
-		data += fmt.Sprintf("%v:%v:%08x", k, len(v), adler32.Checksum(v))
+		data += fmt.Sprintf("%v:%v", k, fingerprint(c.secret.Data[k]))
 	}
 	return data
 }
 
+// fingerprint returns sha256 of the DER certificate or the key data truncated to 8 bytes, 'none' if there is no data
+func fingerprint(data []byte) string {
+	if len(data) == 0 {
+		return "none"
+	}
+	if block, _ := pem.Decode(data); block != nil && block.Type == "CERTIFICATE" {
+		data = block.Bytes
+	}
+	sum := sha256.Sum256(data)
+	return fmt.Sprintf("sha256:%x", sum[:8])
+}
+
 func (c *Secret) markForWrite(mark bool) {
 	c.mustWrite = mark
 }
@@ -57,7 +109,53 @@ func (c *Secret) fillCerts(certsDir string, certs map[string][]byte) {
 	if !c.mustWrite {
 		return
 	}
-	for name, data := range c.secret.Data {
-		certs[c.path(certsDir, name)] = data
+	// nginx needs the certificate and the key only, other data like ca.crt is not written
+	for _, name := range []string{core.TLSCertKey, core.TLSPrivateKeyKey} {
+		if data := c.secret.Data[name]; len(data) > 0 {
+			certs[c.path(certsDir, name)] = data
+		}
+	}
+}
+
+// certificate returns the parsed certificate, parses it if it is not cached
+func (c *Secret) certificate() *certificate {
+	if c.parsed == nil {
+		c.parsed = parseCertificate(c.secret)
+	}
+	return c.parsed
+}
+
+// validate checks the certificate and the key: PEM, the key matches the certificate, validity period
+func (c *Secret) validate(now time.Time) error {
+	parsed := c.certificate()
+	if parsed.err != nil {
+		return parsed.err
+	}
+	cert := parsed.cert
+	if now.After(cert.NotAfter) {
+		return errors.New(fmt.Sprintf("certificate of TLS secret %v expired at %v", c.name(), cert.NotAfter.UTC()))
+	}
+	if now.Before(cert.NotBefore) {
+		return errors.New(fmt.Sprintf("certificate of TLS secret %v is not valid before %v", c.name(), cert.NotBefore.UTC()))
+	}
+	return nil
+}
+
+// covers returns true if the SANs of the certificate cover the host,
+// the wildcard host is covered by the same wildcard SAN only
+func (c *Secret) covers(host string) bool {
+	parsed := c.certificate()
+	if parsed.err != nil {
+		return false
+	}
+	cert := parsed.cert
+	if isWildcard(host) {
+		for _, name := range cert.DNSNames {
+			if strings.EqualFold(name, host) {
+				return true
+			}
+		}
+		return false
 	}
+	return cert.VerifyHostname(host) == nil
 }
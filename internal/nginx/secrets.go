@@ -1,45 +1,159 @@
 package nginx
 
 import (
-	core "k8s.io/api/core/v1"
+	listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
 	"k8s.io/klog/v2"
+	"path/filepath"
+	"time"
 )
 
+// fallbacks of a host without valid TLS secret, -tls-fallback values
+const (
+	fallbackHttp     = "http"      // served by http only
+	fallbackDefault  = "default"   // the default certificate, http if it is not valid
+	fallbackLastGood = "last-good" // the last valid certificate of the host, the default one if there is no one
+)
+
+const lastGoodDir = "_last-good" // namespaces can not start with '_'
+
 type Secrets struct {
-	secrets map[string]*Secret
+	lister  listers.SecretLister
+	secrets map[string]*Secret // secrets used by the current render
+	// name of the default certificate secret 'namespace/name', empty - not set
+	defaultCertificate string
+	fallback           string
+	lastGood           map[string]*Secret // host -> the last valid secret of it
+	// secret name -> the parsed certificate of its resource version, the secrets used by the last render
+	certificates map[string]*certificate
 }
 
-func newSecrets() *Secrets {
+func newSecrets(lister listers.SecretLister, defaultCertificate string, fallback string) *Secrets {
+	switch fallback {
+	case fallbackHttp, fallbackDefault, fallbackLastGood:
+	default:
+		klog.Fatalf("error: unknown TLS fallback: %v, expected %v, %v or %v",
+			fallback, fallbackHttp, fallbackDefault, fallbackLastGood)
+	}
 	return &Secrets{
-		secrets: make(map[string]*Secret),
+		lister:             lister,
+		secrets:            make(map[string]*Secret),
+		defaultCertificate: defaultCertificate,
+		fallback:           fallback,
+		lastGood:           make(map[string]*Secret),
+		certificates:       make(map[string]*certificate),
 	}
 }
 
-func (c *Secrets) add(secret *core.Secret) {
-	s := newSecret(secret)
-	c.secrets[s.name()] = s // overwrite if exist
-	klog.Infof("added <SECRET:%v>(%v)", s.name(), s.string())
+func (c *Secrets) get(secretName string) *Secret {
+	secret, ok := c.secrets[secretName]
+	if ok {
+		return secret
+	}
+	namespace, name, err := cache.SplitMetaNamespaceKey(secretName)
+	if err != nil || len(name) == 0 {
+		return nil
+	}
+	s, err := c.lister.Secrets(namespace).Get(name)
+	if err != nil {
+		return nil
+	}
+	secret = newSecret(s)
+	if parsed, ok := c.certificates[secretName]; ok && parsed.resourceVersion == s.ResourceVersion {
+		secret.parsed = parsed
+	} else if len(s.ResourceVersion) > 0 {
+		// secrets without the resource version are parsed by every render
+		c.certificates[secretName] = secret.certificate()
+	}
+	c.secrets[secretName] = secret
+	return secret
 }
 
-func (c *Secrets) remove(secret *core.Secret) {
-	s := newSecret(secret)
-	delete(c.secrets, s.name())
-	klog.Infof("removed <SECRET:%v>(%v)", s.name(), s.string())
+// reset forgets secrets used by the previous render and the certificates of secrets not used by it
+func (c *Secrets) reset() {
+	for name := range c.certificates {
+		if _, ok := c.secrets[name]; !ok {
+			delete(c.certificates, name)
+		}
+	}
+	c.secrets = make(map[string]*Secret)
+	for _, secret := range c.lastGood {
+		secret.markForWrite(false)
+	}
 }
 
-func (c *Secrets) get(secretName string) *Secret {
-	secret, _ := c.secrets[secretName]
-	return secret
+// keepGood remembers the valid secret of the host for the last-good fallback
+func (c *Secrets) keepGood(host string, secret *Secret) {
+	if c.fallback != fallbackLastGood {
+		return
+	}
+	c.lastGood[host] = &Secret{secret: secret.secret, dir: filepath.Join(lastGoodDir, host), parsed: secret.parsed}
 }
 
-func (c *Secrets) resetWriteMarks() {
-	for _, secret := range c.secrets {
-		secret.markForWrite(false)
+// prune forgets the last valid secrets of the hosts not rendered anymore
+func (c *Secrets) prune(hosts map[string]*Host) {
+	for host := range c.lastGood {
+		if _, ok := hosts[host]; !ok {
+			klog.Infof("HOST:%v> last good certificate dropped, host not rendered", host)
+			delete(c.lastGood, host)
+		}
 	}
 }
 
+// fallbackFor returns the secret of the host without valid TLS secret by the policy and its description,
+// nil if the host is served by http
+func (c *Secrets) fallbackFor(host string) (*Secret, string) {
+	if c.fallback == fallbackLastGood {
+		if secret, ok := c.lastGood[host]; ok && secret.validate(time.Now()) == nil {
+			return secret, "the last good certificate"
+		}
+	}
+	if c.fallback != fallbackHttp {
+		if secret := c.defaultSecret(); secret != nil {
+			return secret, "the default certificate"
+		}
+	}
+	return nil, "http"
+}
+
+// defaultSecret returns the valid default certificate, nil if it is not set or not valid
+func (c *Secrets) defaultSecret() *Secret {
+	if len(c.defaultCertificate) == 0 {
+		return nil
+	}
+	secret := c.get(c.defaultCertificate)
+	if secret == nil {
+		klog.Errorf("default certificate <SECRET:%v> NOT found", c.defaultCertificate)
+		return nil
+	}
+	if err := secret.validate(time.Now()); err != nil {
+		klog.Errorf("default certificate invalid: %v", err)
+		return nil
+	}
+	return secret
+}
+
 func (c *Secrets) fillCerts(certsDir string, certs map[string][]byte) {
 	for _, secret := range c.secrets {
 		secret.fillCerts(certsDir, certs)
 	}
+	for _, secret := range c.lastGood {
+		secret.fillCerts(certsDir, certs)
+	}
+}
+
+// used returns number of secrets written by the current render
+func (c *Secrets) used() int {
+	n := 0
+	for _, secret := range c.secrets {
+		if secret.mustWrite {
+			n++
+		}
+	}
+	for _, secret := range c.lastGood {
+		if secret.mustWrite {
+			n++
+		}
+	}
+	return n
 }
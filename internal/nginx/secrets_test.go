@@ -0,0 +1,152 @@
+package nginx
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	core "k8s.io/api/core/v1"
+	networking "k8s.io/api/networking/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"math/big"
+	"testing"
+	"time"
+)
+
+// testSecret returns TLS secret with self-signed certificate of the names
+func testSecret(t *testing.T, name string, names ...string) *core.Secret {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: names[0]}, DNSNames: names,
+		NotBefore: time.Now().Add(-time.Hour), NotAfter: time.Now().Add(time.Hour)}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &core.Secret{ObjectMeta: meta.ObjectMeta{Name: name, Namespace: "ns"}, Type: core.SecretTypeTLS,
+		Data: map[string][]byte{
+			core.TLSCertKey:       pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+			core.TLSPrivateKeyKey: pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}),
+		}}
+}
+
+// testSecrets returns secrets of the lister with the objects
+func testSecrets(t *testing.T, defaultCertificate string, fallback string, objects ...*core.Secret) (*Secrets, cache.Indexer) {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for _, object := range objects {
+		if err := indexer.Add(object); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return newSecrets(listers.NewSecretLister(indexer), defaultCertificate, fallback), indexer
+}
+
+func testTLSHost(secrets *Secrets, host string, secretName string) *Host {
+	c := newHost(&networking.IngressRule{Host: host}, secrets, newServices(nil))
+	c.attachTLSSecret(secretName, &networking.Ingress{ObjectMeta: meta.ObjectMeta{Name: "ing", Namespace: "ns"}})
+	return c
+}
+
+func TestSelectTLSSecretNotFound(t *testing.T) {
+	cases := []struct {
+		fallback string
+		secret   string // expected secret name, empty - http
+	}{
+		{fallbackHttp, ""},
+		{fallbackDefault, "ns/default"},
+		{fallbackLastGood, "ns/default"},
+	}
+	for _, c := range cases {
+		t.Run(c.fallback, func(t *testing.T) {
+			secrets, _ := testSecrets(t, "ns/default", c.fallback, testSecret(t, "default", "default.example.com"))
+			host := testTLSHost(secrets, "a.example.com", "ns/missing")
+			name := ""
+			if secret := host.selectTLSSecret(); secret != nil {
+				name = secret.name()
+			}
+			if name != c.secret {
+				t.Errorf("secret %q, expected %q", name, c.secret)
+			}
+			if len(host.skipped) != 1 || host.skipped[0].reason != reasonSecretNotFound {
+				t.Errorf("problems %+v, expected %v", host.skipped, reasonSecretNotFound)
+			}
+		})
+	}
+}
+
+func TestSelectTLSSecretLastGoodNotFound(t *testing.T) {
+	secrets, indexer := testSecrets(t, "", fallbackLastGood, testSecret(t, "tls", "a.example.com"))
+	if testTLSHost(secrets, "a.example.com", "ns/tls").selectTLSSecret() == nil {
+		t.Fatal("valid secret not selected")
+	}
+
+	// the secret is deleted
+	if err := indexer.Delete(&core.Secret{ObjectMeta: meta.ObjectMeta{Name: "tls", Namespace: "ns"}}); err != nil {
+		t.Fatal(err)
+	}
+	secrets.reset()
+	secret := testTLSHost(secrets, "a.example.com", "ns/tls").selectTLSSecret()
+	if secret == nil || secret.dir != lastGoodDir+"/a.example.com" {
+		t.Errorf("secret %+v, expected the last good one", secret)
+	}
+}
+
+func TestLastGoodPruned(t *testing.T) {
+	secrets, _ := testSecrets(t, "", fallbackLastGood, testSecret(t, "a", "a.example.com"), testSecret(t, "b", "b.example.com"))
+	hosts := map[string]*Host{
+		"a.example.com": testTLSHost(secrets, "a.example.com", "ns/a"),
+		"b.example.com": testTLSHost(secrets, "b.example.com", "ns/b"),
+	}
+	for _, host := range hosts {
+		host.selectTLSSecret()
+	}
+
+	delete(hosts, "b.example.com")
+	secrets.prune(hosts)
+	if _, ok := secrets.lastGood["a.example.com"]; !ok {
+		t.Errorf("last good of the rendered host pruned")
+	}
+	if _, ok := secrets.lastGood["b.example.com"]; ok {
+		t.Errorf("last good of the removed host kept")
+	}
+}
+
+func TestCertificateCachedByResourceVersion(t *testing.T) {
+	secret := testSecret(t, "tls", "a.example.com")
+	secret.ResourceVersion = "1"
+	secrets, indexer := testSecrets(t, "", fallbackHttp, secret)
+	parsed := secrets.get("ns/tls").certificate()
+
+	secrets.reset()
+	if secrets.get("ns/tls").parsed != parsed {
+		t.Errorf("certificate of the same resource version parsed again")
+	}
+
+	// the secret is updated
+	updated := testSecret(t, "tls", "b.example.com")
+	updated.ResourceVersion = "2"
+	if err := indexer.Update(updated); err != nil {
+		t.Fatal(err)
+	}
+	secrets.reset()
+	if secret := secrets.get("ns/tls"); secret.parsed == parsed || !secret.covers("b.example.com") {
+		t.Errorf("certificate of the updated secret is not parsed")
+	}
+
+	// the secret is not used by the render
+	secrets.reset()
+	secrets.reset()
+	if _, ok := secrets.certificates["ns/tls"]; ok {
+		t.Errorf("certificate of the unused secret kept")
+	}
+}
@@ -2,35 +2,62 @@ package nginx
 
 import (
 	"fmt"
-	"strings"
+	networking "k8s.io/api/networking/v1"
+	"k8s.io/klog/v2"
+	"ngress/internal/conf"
+	"sort"
 )
 
 type Server struct {
 	*ProtoOpts
+	render            *RenderOpts
 	addAltSvc         bool
 	name              string
 	sslCertPath       string
 	sslCertKeyPath    string
 	routes            []*Route
 	blockRootLocation bool
+	listenPorts       []uint16 // not empty for the default server
+	securePorts       []uint16 // secure ports of the default server
+	quicPorts         []uint16 // quic ports of the default server, it owns reuseport of them
+	rejectHandshake   bool     // the default server has no certificate
+	aliases           []string
+	redirectFrom      string     // redirected to the name if set
+	redirectHttps     bool       // certificate covers redirectFrom
+	duplicates        []*Problem // locations dropped by addLocations
 }
 
-func newServer(name string, opts *ProtoOpts) *Server {
+func newServer(name string, opts *ProtoOpts, render *RenderOpts) *Server {
 	return &Server{
 		name:      name,
 		ProtoOpts: opts,
+		render:    render,
 		routes:    make([]*Route, 0),
 	}
 }
 
-func (c *Server) writeServerForwardBlock(sb *strings.Builder) {
-	sb.WriteString(fmt.Sprintf(`
-server { 
- listen %v;
- server_name %v;
- return 301 https://$host$request_uri; 
+func (c *Server) serverForwardBlock() *conf.Directive {
+	return conf.Block("server").Add(c.listen(c.ProtoOpts.unsecurePort)...).Add(
+		c.serverNames(),
+		conf.New("return", "301", "https://$host$request_uri"))
 }
-`, c.ProtoOpts.unsecurePort, c.name))
+
+// listen returns listen directives of the port on IPv4 and, if enabled, IPv6 addresses
+func (c *Server) listen(port uint16, params ...string) []*conf.Directive {
+	listens := []*conf.Directive{conf.New("listen", append([]string{fmt.Sprint(port)}, params...)...)}
+	if c.render.ipv6 {
+		listens = append(listens, conf.New("listen", append([]string{fmt.Sprintf("[::]:%v", port)}, params...)...))
+	}
+	return listens
+}
+
+// serverNames returns server_name of the name and aliases of the server
+func (c *Server) serverNames() *conf.Directive {
+	d := conf.New("server_name").Literal(serverName(c.name))
+	for _, alias := range c.aliases {
+		d.Literal(alias)
+	}
+	return d
 }
 
 func (c *Server) addRoute(route *Route) {
@@ -41,59 +68,131 @@ func (c *Server) addBlockRootLocation() {
 	c.blockRootLocation = true
 }
 
-func (c *Server) write(sb *strings.Builder) {
-	// makes two server blocks -> redirect from unsecure to https
+// directives returns server blocks of the host, two blocks for https -> redirect from unsecure to https
+func (c *Server) directives() []*conf.Directive {
 	https := len(c.sslCertPath) > 0 && len(c.sslCertKeyPath) > 0
+	if len(c.listenPorts) > 0 {
+		return []*conf.Directive{c.defaultServer()}
+	}
 	if len(c.routes) == 0 {
-		return
+		return nil
 	}
 
+	var servers []*conf.Directive
+	if len(c.redirectFrom) > 0 {
+		servers = append(servers, c.redirectServers(https && c.redirectHttps)...)
+	}
+
+	server := conf.Block("server")
 	if !https {
-		sb.WriteString("\nserver { ")
-		sb.WriteString(fmt.Sprintf(`
- listen %v;
- server_name %v;`,
-			c.unsecurePort, c.name))
+		server.Add(c.listen(c.unsecurePort)...).Add(c.serverNames())
 	} else {
-		c.writeServerForwardBlock(sb)
-		sb.WriteString(`
-server {
- ssl_protocols TLSv1.2 TLSv1.3;
- ssl_session_timeout 10m;
- ssl_session_cache shared:SSL:10m;`)
-
-		sb.WriteString(fmt.Sprintf(`
- listen %v ssl;
- server_name %v;`, c.securePort, c.name))
+		servers = append(servers, c.serverForwardBlock())
+		server.Add(
+			conf.New("ssl_protocols", "TLSv1.2", "TLSv1.3"),
+			conf.New("ssl_session_timeout", "10m"),
+			conf.New("ssl_session_cache", "shared:SSL:10m")).
+			Add(c.listen(c.securePort, "ssl")...).
+			Add(c.serverNames())
 
 		if c.http2 {
-			sb.WriteString("\n http2 on;")
+			server.Add(conf.New("http2", "on"))
 		}
 
 		if c.http3 {
-			sb.WriteString(fmt.Sprintf(`
- http3 on;
- listen %v quic reuseport;
- ssl_early_data on;`,
-				c.securePort))
+			server.Add(conf.New("http3", "on")).
+				Add(c.listen(c.securePort, "quic")...).
+				Add(conf.New("ssl_early_data", "on"))
 		}
 
-		sb.WriteString(fmt.Sprintf(`
- ssl_certificate %v;
- ssl_certificate_key %v;
-`,
-			c.sslCertPath,
-			c.sslCertKeyPath))
+		server.Add(
+			conf.New("ssl_certificate").Value(c.sslCertPath),
+			conf.New("ssl_certificate_key").Value(c.sslCertKeyPath))
 	}
 
+	c.addLocations(server)
+	return append(servers, server)
+}
+
+// defaultServer returns the server of requests to unknown hosts
+func (c *Server) defaultServer() *conf.Directive {
+	server := conf.Block("server")
+	for _, port := range c.listenPorts {
+		server.Add(c.listen(port, "default_server")...)
+	}
+	for _, port := range c.securePorts {
+		server.Add(c.listen(port, "ssl", "default_server")...)
+	}
+	for _, port := range c.quicPorts {
+		// reuseport is allowed once per port, servers of the hosts listen quic without it
+		server.Add(c.listen(port, "quic", "reuseport", "default_server")...)
+	}
+	server.Add(conf.New("server_name", "_"))
+	if c.rejectHandshake {
+		// unknown SNI never gets a certificate of a host
+		server.Add(conf.New("ssl_reject_handshake", "on"))
+	} else if len(c.securePorts) > 0 {
+		server.Add(
+			conf.New("ssl_certificate").Value(c.sslCertPath),
+			conf.New("ssl_certificate_key").Value(c.sslCertKeyPath))
+	}
+	c.addLocations(server)
+	return server
+}
+
+func (c *Server) addLocations(server *conf.Directive) {
 	if c.blockRootLocation {
-		locationRoot444(sb)
+		server.Add(locationRoot444())
 	}
 
 	// alt-svc header actual only for https!
-	for _, location := range c.routes {
-		location.write(c.addAltSvc, c.ProtoOpts, sb)
+	// routes are sorted by key, so Exact routes are written before the same '= ' location of Prefix ones
+	written := make(map[string]*Route)
+	c.duplicates = nil
+	for _, route := range orderRoutes(c.routes) {
+		for _, location := range route.locations() {
+			if r, ok := written[location.string()]; ok {
+				if overridesExactMatch(r, route, location) {
+					klog.V(2).Infof("HOST:%v> location %v already written, skip it of %v", c.name, location.string(), route.string())
+					continue
+				}
+				klog.Warningf("HOST:%v> location %v already written by %v, skip it of %v", c.name, location.string(), r.string(), route.string())
+				c.duplicates = append(c.duplicates, &Problem{ingress: route.owner, reason: reasonDuplicateRoute,
+					message: fmt.Sprintf("location %v of route %v%v ignored, already defined by ingress %v",
+						location.string(), c.name, route.path.Path, ingressName(r.owner))})
+				continue
+			}
+			written[location.string()] = route
+			server.Add(route.location(location, c.trafficHost(), c.addAltSvc, c.ProtoOpts, c.render))
+		}
+	}
+}
+
+// trafficHost returns the host label of the traffic log, '_' of the default server
+func (c *Server) trafficHost() string {
+	if len(c.name) == 0 {
+		return "_"
 	}
+	return c.name
+}
 
-	sb.WriteString("\n}\n")
+// overridesExactMatch is true if the Exact route takes precedence over the exact match location of the Prefix route
+func overridesExactMatch(exact *Route, prefix *Route, location Location) bool {
+	return location.modifier == "=" && len(exact.regex) == 0 && len(prefix.regex) == 0 &&
+		pathType(exact.path) == networking.PathTypeExact && pathType(prefix.path) == networking.PathTypePrefix
+}
+
+// orderRoutes keeps the order of the routes, regex routes are moved to the end
+// ordered by path length descending, so nginx checks more specific regex first
+func orderRoutes(routes []*Route) []*Route {
+	ordered := make([]*Route, len(routes))
+	copy(ordered, routes)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		a, b := ordered[i], ordered[j]
+		if len(a.regex) == 0 || len(b.regex) == 0 {
+			return len(a.regex) == 0 && len(b.regex) > 0
+		}
+		return len(a.path.Path) > len(b.path.Path)
+	})
+	return ordered
 }
@@ -0,0 +1,66 @@
+package nginx
+
+import (
+	core "k8s.io/api/core/v1"
+	networking "k8s.io/api/networking/v1"
+	"ngress/internal/conf"
+	"strings"
+	"testing"
+)
+
+// testDefaultServer renders the default server of a TLS host with http3 on the port 8443 and a plain http host
+func testDefaultServer(t *testing.T, defaultCertificate string) string {
+	secrets, _ := testSecrets(t, defaultCertificate, fallbackHttp,
+		testSecret(t, "tls", "a.example.com"), testSecret(t, "default", "default.example.com"))
+	services := newServices([]*core.Service{testService("svc")})
+	c := &Controller{ingresses: make(map[string]*Ingress), quarantined: make(map[string]*Quarantined),
+		secrets: secrets, services: services}
+
+	secure := testIngress("secure", "a.example.com", "svc")
+	secure.Annotations = map[string]string{"ngress.proto/http3": "true", "ngress.port/secure": "8443"}
+	secure.Spec.TLS = []networking.IngressTLS{{Hosts: []string{"a.example.com"}, SecretName: "tls"}}
+	for _, ingress := range []*networking.Ingress{secure, testIngress("plain", "b.example.com", "svc")} {
+		c.ingresses[ingressName(ingress)] = newIngress(ingress, secrets, services, "node")
+	}
+
+	hosts := c.buildHosts(c.contributing())
+	var sb strings.Builder
+	if err := conf.Render(&sb, hosts[""].buildServers(&RenderOpts{certsDir: "/certs"})...); err != nil {
+		t.Fatal(err)
+	}
+	return sb.String()
+}
+
+func TestDefaultTLSServer(t *testing.T) {
+	listen := `
+server {
+ listen 80 default_server;
+ listen 8443 ssl default_server;
+ listen 8443 quic reuseport default_server;
+ server_name _;
+`
+	cases := []struct {
+		name               string
+		defaultCertificate string
+		expected           string
+	}{
+		{"unknown SNI is rejected without the default certificate", "", listen + ` ssl_reject_handshake on;
+ location / {
+  return 444;
+ }
+}
+`},
+		{"unknown SNI gets the default certificate", "ns/default", listen + ` ssl_certificate "/certs/ns/default/tls.crt";
+ ssl_certificate_key "/certs/ns/default/tls.key";
+ location / {
+  return 444;
+ }
+}
+`},
+	}
+	for _, c := range cases {
+		if config := testDefaultServer(t, c.defaultCertificate); config != c.expected {
+			t.Errorf("%v: rendered\n%v\nexpected\n%v", c.name, config, c.expected)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package nginx
+
+import (
+	"fmt"
+	core "k8s.io/api/core/v1"
+	networking "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"strings"
+)
+
+// Services is the port model of the services known to the controller
+type Services struct {
+	ports         map[string][]core.ServicePort // name.namespace -> all ports of the service
+	externalNames map[string]string             // name.namespace -> external hostname of ExternalName service
+}
+
+func newServices(services []*core.Service) *Services {
+	c := &Services{ports: make(map[string][]core.ServicePort), externalNames: make(map[string]string)}
+	for _, service := range services {
+		name := serviceName(service.Name, service.Namespace)
+		c.ports[name] = service.Spec.Ports
+		if service.Spec.Type == core.ServiceTypeExternalName && len(service.Spec.ExternalName) > 0 {
+			c.externalNames[name] = strings.TrimSuffix(strings.ToLower(service.Spec.ExternalName), ".")
+		}
+	}
+	return c
+}
+
+// externalName returns the external hostname of ExternalName service, empty for other services
+func (c *Services) externalName(namespace string, backend *networking.IngressServiceBackend) string {
+	return c.externalNames[serviceName(backend.Name, namespace)]
+}
+
+func serviceName(name string, namespace string) string {
+	return fmt.Sprintf("%v.%v", name, namespace)
+}
+
+// port resolves the backend port by number or by name to the port of the service,
+// returns nil if the service or its port not found
+func (c *Services) port(namespace string, backend *networking.IngressServiceBackend) *core.ServicePort {
+	ports, ok := c.ports[serviceName(backend.Name, namespace)]
+	if !ok {
+		return nil
+	}
+	for _, port := range ports {
+		if port.Protocol != "" && port.Protocol != core.ProtocolTCP {
+			continue
+		}
+		if len(backend.Port.Name) > 0 {
+			if port.Name == backend.Port.Name {
+				return &port
+			}
+		} else if port.Port == backend.Port.Number {
+			return &port
+		}
+	}
+	// ExternalName service has no ports usually, the backend port number is the port of the external host
+	if _, ok := c.externalNames[serviceName(backend.Name, namespace)]; ok && backend.Port.Number != 0 {
+		return &core.ServicePort{Port: backend.Port.Number, TargetPort: intstr.FromInt32(backend.Port.Number)}
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package nginx
+
+import (
+	core "k8s.io/api/core/v1"
+	networking "k8s.io/api/networking/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"testing"
+)
+
+func TestServicePort(t *testing.T) {
+	multi := &core.Service{ObjectMeta: meta.ObjectMeta{Name: "multi", Namespace: "ns"},
+		Spec: core.ServiceSpec{Ports: []core.ServicePort{
+			{Name: "dns", Port: 53, Protocol: core.ProtocolUDP, TargetPort: intstr.FromInt32(5353)},
+			{Name: "http", Port: 80, TargetPort: intstr.FromString("web")},
+			{Name: "metrics", Port: 9090, Protocol: core.ProtocolTCP, TargetPort: intstr.FromInt32(9091)},
+		}}}
+	external := &core.Service{ObjectMeta: meta.ObjectMeta{Name: "external", Namespace: "ns"},
+		Spec: core.ServiceSpec{Type: core.ServiceTypeExternalName, ExternalName: "Example.COM."}}
+	services := newServices([]*core.Service{multi, external})
+
+	cases := []struct {
+		name    string
+		service string
+		port    networking.ServiceBackendPort
+		found   int32
+	}{
+		{"first port by number", "multi", networking.ServiceBackendPort{Number: 80}, 80},
+		{"other port by number", "multi", networking.ServiceBackendPort{Number: 9090}, 9090},
+		{"port by name", "multi", networking.ServiceBackendPort{Name: "metrics"}, 9090},
+		{"unknown port name", "multi", networking.ServiceBackendPort{Name: "grpc"}, 0},
+		{"unknown port number", "multi", networking.ServiceBackendPort{Number: 8080}, 0},
+		{"udp port by name", "multi", networking.ServiceBackendPort{Name: "dns"}, 0},
+		{"unknown service", "missing", networking.ServiceBackendPort{Number: 80}, 0},
+		{"port of external name", "external", networking.ServiceBackendPort{Number: 443}, 443},
+	}
+	for _, c := range cases {
+		port := services.port("ns", &networking.IngressServiceBackend{Name: c.service, Port: c.port})
+		if c.found == 0 && port != nil {
+			t.Errorf("%v: port %v, expected not found", c.name, port.Port)
+		} else if c.found != 0 && (port == nil || port.Port != c.found) {
+			t.Errorf("%v: port %v, expected %v", c.name, port, c.found)
+		}
+	}
+}
+
+func TestRouteOfNamedPort(t *testing.T) {
+	services := newServices([]*core.Service{{ObjectMeta: meta.ObjectMeta{Name: "svc", Namespace: "ns"},
+		Spec: core.ServiceSpec{Ports: []core.ServicePort{
+			{Name: "http", Port: 80, TargetPort: intstr.FromInt32(8080)},
+			{Name: "admin", Port: 8081, TargetPort: intstr.FromString("admin")},
+		}}}})
+	route := testRoute(services, networking.PathTypePrefix, "/", "svc")
+	route.path.Backend.Service.Port = networking.ServiceBackendPort{Name: "admin"}
+	route.resolve(services)
+
+	if route.port != 8081 || route.portName != "admin" || route.targetPort != 0 {
+		t.Errorf("port %v name %v target port %v, expected 8081 admin 0", route.port, route.portName, route.targetPort)
+	}
+	if destination := route.destination(); destination != "svc.ns:8081" {
+		t.Errorf("destination %v, expected svc.ns:8081", destination)
+	}
+	if name := route.upstreamName(); name != "svc_ns_8081" {
+		t.Errorf("upstream %v, expected svc_ns_8081", name)
+	}
+}
@@ -0,0 +1,235 @@
+package nginx
+
+import (
+	"context"
+	core "k8s.io/api/core/v1"
+	networking "k8s.io/api/networking/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"k8s.io/klog/v2"
+	"net"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// StatusWriter publishes addresses of the nodes serving ingresses into status.loadBalancer.
+// Only the leader (coordination.k8s.io Lease) writes statuses.
+type StatusWriter struct {
+	tag          string
+	client       kubernetes.Interface
+	controller   *Controller
+	namespace    string
+	podName      string
+	interval     time.Duration
+	electionID   string
+	publishAddrs []networking.IngressLoadBalancerIngress
+	cancel       context.CancelFunc
+}
+
+func newStatusWriter(opts *Opts, client kubernetes.Interface, controller *Controller) *StatusWriter {
+	c := &StatusWriter{
+		tag:        "STATUS",
+		client:     client,
+		controller: controller,
+		namespace:  os.Getenv("POD_NAMESPACE"),
+		podName:    os.Getenv("POD_NAME"),
+		interval:   *opts.statusUpdateInterval,
+		electionID: *opts.electionID,
+	}
+	for _, addr := range strings.Split(*opts.publishStatusAddress, ",") {
+		addr = strings.TrimSpace(addr)
+		if len(addr) > 0 {
+			c.publishAddrs = append(c.publishAddrs, loadBalancerIngress(addr))
+		}
+	}
+	return c
+}
+
+func loadBalancerIngress(addr string) networking.IngressLoadBalancerIngress {
+	if net.ParseIP(addr) != nil {
+		return networking.IngressLoadBalancerIngress{IP: addr}
+	}
+	return networking.IngressLoadBalancerIngress{Hostname: addr}
+}
+
+func (c *StatusWriter) start() {
+	if len(c.namespace) == 0 || len(c.podName) == 0 {
+		klog.Warningf("%v> POD_NAMESPACE or POD_NAME env not set, status update disabled", c.tag)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c.cancel = cancel
+
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta:  meta.ObjectMeta{Name: c.electionID, Namespace: c.namespace},
+		Client:     c.client.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{Identity: c.podName},
+	}
+	config := leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		ReleaseOnCancel: true,
+		LeaseDuration:   30 * time.Second,
+		RenewDeadline:   20 * time.Second,
+		RetryPeriod:     5 * time.Second,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: c.run,
+			OnStoppedLeading: func() {
+				klog.Infof("%v> stopped leading", c.tag)
+			},
+			OnNewLeader: func(identity string) {
+				klog.Infof("%v> leader: %v", c.tag, identity)
+			},
+		},
+	}
+
+	go func() {
+		// RunOrDie returns on leadership lost, try to acquire it again until stopped
+		for ctx.Err() == nil {
+			leaderelection.RunOrDie(ctx, config)
+		}
+	}()
+}
+
+func (c *StatusWriter) stop() {
+	if c.cancel != nil {
+		c.cancel()
+	}
+}
+
+func (c *StatusWriter) run(ctx context.Context) {
+	klog.Infof("%v> started leading, update status every %v", c.tag, c.interval)
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+	for {
+		c.update(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// nodeAddresses returns addresses of the nodes running ngress pods: map node name -> address
+func (c *StatusWriter) nodeAddresses(ctx context.Context) (map[string]networking.IngressLoadBalancerIngress, error) {
+	pod, err := c.client.CoreV1().Pods(c.namespace).Get(ctx, c.podName, meta.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	selector := labels.SelectorFromSet(pod.Labels).String()
+	pods, err := c.client.CoreV1().Pods(c.namespace).List(ctx, meta.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return nil, err
+	}
+
+	addresses := make(map[string]networking.IngressLoadBalancerIngress)
+	for _, p := range pods.Items {
+		if len(p.Spec.NodeName) == 0 || p.Status.Phase != core.PodRunning {
+			continue
+		}
+		if _, ok := addresses[p.Spec.NodeName]; ok {
+			continue
+		}
+		node, err := c.client.CoreV1().Nodes().Get(ctx, p.Spec.NodeName, meta.GetOptions{})
+		if err != nil {
+			klog.Errorf("%v> error: %v, getting node: %v", c.tag, err, p.Spec.NodeName)
+			continue
+		}
+		addr := nodeAddress(node)
+		if len(addr) == 0 {
+			klog.Warningf("%v> node %v has no external or internal IP", c.tag, node.Name)
+			continue
+		}
+		addresses[node.Name] = networking.IngressLoadBalancerIngress{IP: addr}
+		if hostname := node.Labels[core.LabelHostname]; len(hostname) > 0 && hostname != node.Name {
+			addresses[hostname] = addresses[node.Name]
+		}
+	}
+	return addresses, nil
+}
+
+// nodeAddress prefers ExternalIP over InternalIP
+func nodeAddress(node *core.Node) string {
+	internal := ""
+	for _, addr := range node.Status.Addresses {
+		switch addr.Type {
+		case core.NodeExternalIP:
+			return addr.Address
+		case core.NodeInternalIP:
+			if len(internal) == 0 {
+				internal = addr.Address
+			}
+		}
+	}
+	return internal
+}
+
+func sortedLoadBalancerIngresses(in []networking.IngressLoadBalancerIngress) []networking.IngressLoadBalancerIngress {
+	unique := make(map[string]networking.IngressLoadBalancerIngress)
+	for _, lb := range in {
+		unique[lb.IP+"/"+lb.Hostname] = lb
+	}
+	out := make([]networking.IngressLoadBalancerIngress, 0, len(unique))
+	for _, lb := range unique {
+		out = append(out, lb)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].IP+"/"+out[i].Hostname < out[j].IP+"/"+out[j].Hostname
+	})
+	return out
+}
+
+func (c *StatusWriter) update(ctx context.Context) {
+	if !c.controller.Ready() {
+		klog.Infof("%v> controller not ready, skip status update", c.tag)
+		return
+	}
+
+	var nodes map[string]networking.IngressLoadBalancerIngress
+	if len(c.publishAddrs) == 0 {
+		var err error
+		nodes, err = c.nodeAddresses(ctx)
+		if err != nil {
+			klog.Errorf("%v> error: %v, getting node addresses", c.tag, err)
+			return
+		}
+	}
+
+	for _, ingress := range c.controller.servedIngresses() {
+		var lbs []networking.IngressLoadBalancerIngress
+		if len(c.publishAddrs) > 0 {
+			lbs = c.publishAddrs
+		} else {
+			affinity := newAnnotations(ingress.Annotations).hostAffinity
+			for name, lb := range nodes {
+				if len(affinity) == 0 || affinity == name {
+					lbs = append(lbs, lb)
+				}
+			}
+		}
+		c.updateIngress(ctx, ingress, sortedLoadBalancerIngresses(lbs))
+	}
+}
+
+func (c *StatusWriter) updateIngress(ctx context.Context, ingress *networking.Ingress, lbs []networking.IngressLoadBalancerIngress) {
+	if reflect.DeepEqual(ingress.Status.LoadBalancer.Ingress, lbs) ||
+		(len(ingress.Status.LoadBalancer.Ingress) == 0 && len(lbs) == 0) {
+		return
+	}
+
+	updated := ingress.DeepCopy()
+	updated.Status.LoadBalancer.Ingress = lbs
+	_, err := c.client.NetworkingV1().Ingresses(ingress.Namespace).UpdateStatus(ctx, updated, meta.UpdateOptions{})
+	if err != nil {
+		klog.Errorf("%v> error: %v, updating status of INGRESS:%v", c.tag, err, ingressName(ingress))
+		return
+	}
+	klog.Infof("%v> INGRESS:%v status updated: %+v", c.tag, ingressName(ingress), lbs)
+}
@@ -0,0 +1,89 @@
+package nginx
+
+import (
+	"context"
+	core "k8s.io/api/core/v1"
+	networking "k8s.io/api/networking/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func testNode(name string, labels map[string]string, addresses ...core.NodeAddress) *core.Node {
+	return &core.Node{ObjectMeta: meta.ObjectMeta{Name: name, Labels: labels}, Status: core.NodeStatus{Addresses: addresses}}
+}
+
+func testPod(name string, app string, node string, phase core.PodPhase) *core.Pod {
+	return &core.Pod{ObjectMeta: meta.ObjectMeta{Name: name, Namespace: "ngress", Labels: map[string]string{"app": app}},
+		Spec: core.PodSpec{NodeName: node}, Status: core.PodStatus{Phase: phase}}
+}
+
+func TestNodeAddress(t *testing.T) {
+	external := core.NodeAddress{Type: core.NodeExternalIP, Address: "203.0.113.1"}
+	internal := core.NodeAddress{Type: core.NodeInternalIP, Address: "10.0.0.1"}
+	hostname := core.NodeAddress{Type: core.NodeHostName, Address: "node"}
+	cases := []struct {
+		name      string
+		addresses []core.NodeAddress
+		address   string
+	}{
+		{"external is preferred", []core.NodeAddress{internal, external}, "203.0.113.1"},
+		{"internal without external", []core.NodeAddress{hostname, internal}, "10.0.0.1"},
+		{"first internal", []core.NodeAddress{internal, {Type: core.NodeInternalIP, Address: "10.0.0.2"}}, "10.0.0.1"},
+		{"no ip", []core.NodeAddress{hostname}, ""},
+	}
+	for _, c := range cases {
+		if address := nodeAddress(testNode("node", nil, c.addresses...)); address != c.address {
+			t.Errorf("%v: address %q, expected %q", c.name, address, c.address)
+		}
+	}
+}
+
+func TestStatusOfNodesAndAffinity(t *testing.T) {
+	pinned := testIngress("pinned", "b.example.com", "svc")
+	pinned.Annotations = map[string]string{"ngress.affinity/host": "n1"}
+	objects := []runtime.Object{testService("svc"), testIngress("all", "a.example.com", "svc"), pinned,
+		testPod("ngress-1", "ngress", "n1", core.PodRunning),
+		testPod("ngress-2", "ngress", "n2", core.PodRunning),
+		testPod("ngress-3", "ngress", "n3", core.PodPending),
+		testPod("other-4", "other", "n4", core.PodRunning),
+		testNode("n1", nil, core.NodeAddress{Type: core.NodeInternalIP, Address: "10.0.0.1"},
+			core.NodeAddress{Type: core.NodeExternalIP, Address: "203.0.113.1"}),
+		testNode("n2", map[string]string{core.LabelHostname: "host-2"}, core.NodeAddress{Type: core.NodeInternalIP, Address: "10.0.0.2"}),
+		testNode("n3", nil, core.NodeAddress{Type: core.NodeInternalIP, Address: "10.0.0.3"}),
+		testNode("n4", nil, core.NodeAddress{Type: core.NodeInternalIP, Address: "10.0.0.4"}),
+	}
+	c, client, configPath := testController(t, objects...)
+	waitConfig(t, configPath, 5*time.Second, "a.example.com")
+	waitReady(t, c, true)
+	status := &StatusWriter{tag: "STATUS", client: client, controller: c, namespace: "ngress", podName: "ngress-1"}
+
+	// running pods of the same labels only, the node is also known by its hostname label
+	ctx := context.Background()
+	nodes, err := status.nodeAddresses(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]networking.IngressLoadBalancerIngress{"n1": {IP: "203.0.113.1"}, "n2": {IP: "10.0.0.2"},
+		"host-2": {IP: "10.0.0.2"}}
+	if !reflect.DeepEqual(nodes, expected) {
+		t.Errorf("node addresses %v, expected %v", nodes, expected)
+	}
+
+	status.update(ctx)
+	for name, ips := range map[string][]string{"all": {"10.0.0.2", "203.0.113.1"}, "pinned": {"203.0.113.1"}} {
+		ingress, err := client.NetworkingV1().Ingresses("ns").Get(ctx, name, meta.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var published []string
+		for _, lb := range ingress.Status.LoadBalancer.Ingress {
+			published = append(published, lb.IP)
+		}
+		if !reflect.DeepEqual(published, ips) {
+			t.Errorf("status of %v %v, expected %v", name, published, ips)
+		}
+	}
+}
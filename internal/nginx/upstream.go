@@ -0,0 +1,56 @@
+package nginx
+
+import (
+	"fmt"
+	"ngress/internal/conf"
+	"ngress/internal/utils"
+)
+
+// Upstream is a named upstream of the service port with its ready endpoints
+type Upstream struct {
+	name    string
+	servers []string
+	backups []string // remote endpoints, used if no local endpoint is available
+}
+
+// newUpstream makes the upstream of the route, if node is not empty
+// endpoints of the node are primary servers and endpoints of other nodes are backup ones
+func newUpstream(route *Route, endpoints *Endpoints, node string) *Upstream {
+	c := &Upstream{name: route.upstreamName()}
+	addresses := endpoints.addresses(route.namespace, route.path.Backend.Service.Name, route.portName)
+	if len(node) > 0 {
+		c.name += "_local"
+		for _, address := range utils.SortedKeys(addresses) {
+			if addresses[address] == node {
+				c.servers = append(c.servers, address)
+			} else {
+				c.backups = append(c.backups, address)
+			}
+		}
+		if len(c.servers) == 0 {
+			// nginx requires a primary server, remote endpoints are primary if no local ones
+			c.servers, c.backups = c.backups, nil
+		}
+		return c
+	}
+	c.servers = utils.SortedKeys(addresses)
+	return c
+}
+
+func (c *Upstream) directive(keepalive int) *conf.Directive {
+	upstream := conf.Block("upstream", c.name)
+	if len(c.servers) == 0 {
+		// upstream must have a server, requests get 502
+		upstream.Add(conf.New("server", "127.0.0.1:1", "down"))
+	}
+	for _, server := range c.servers {
+		upstream.Add(conf.New("server", server))
+	}
+	for _, server := range c.backups {
+		upstream.Add(conf.New("server", server, "backup"))
+	}
+	if keepalive > 0 {
+		upstream.Add(conf.New("keepalive", fmt.Sprint(keepalive)))
+	}
+	return upstream
+}
@@ -0,0 +1,67 @@
+package nginx
+
+import (
+	core "k8s.io/api/core/v1"
+	discovery "k8s.io/api/discovery/v1"
+	networking "k8s.io/api/networking/v1"
+	"ngress/internal/conf"
+	"strings"
+	"testing"
+)
+
+// testEndpoints returns endpoints of the service 'svc': ready ones of the ips on their nodes
+// and not ready and terminating ones which are never rendered
+func testEndpoints(nodes map[string]string) *Endpoints {
+	slice := testSlice("svc", "10.0.1.1")
+	notReady, terminating, node := false, true, "n1"
+	slice.Endpoints = []discovery.Endpoint{
+		{Addresses: []string{"10.0.1.1"}, Conditions: discovery.EndpointConditions{Ready: &notReady}, NodeName: &node},
+		{Addresses: []string{"10.0.1.2"}, Conditions: discovery.EndpointConditions{Terminating: &terminating}, NodeName: &node},
+	}
+	for ip, node := range nodes {
+		slice.Endpoints = append(slice.Endpoints, discovery.Endpoint{Addresses: []string{ip}, NodeName: &node})
+	}
+	return newEndpoints([]*discovery.EndpointSlice{slice})
+}
+
+func TestUpstream(t *testing.T) {
+	services := newServices([]*core.Service{testService("svc")})
+	route := testRoute(services, networking.PathTypePrefix, "/", "svc")
+	cases := []struct {
+		name     string
+		nodes    map[string]string // ip -> node of ready endpoints
+		node     string            // prefer endpoints of the node if not empty
+		expected string
+	}{
+		{"ready endpoints", map[string]string{"10.0.0.2": "n2", "10.0.0.1": "n1"}, "",
+			"\nupstream svc_ns_80 {\n server 10.0.0.1:8080;\n server 10.0.0.2:8080;\n keepalive 16;\n}\n"},
+		{"no ready endpoints", nil, "",
+			"\nupstream svc_ns_80 {\n server 127.0.0.1:1 down;\n keepalive 16;\n}\n"},
+		{"remote endpoints are backup", map[string]string{"10.0.0.1": "n1", "10.0.0.2": "n2", "10.0.0.3": "n1"}, "n1",
+			"\nupstream svc_ns_80_local {\n server 10.0.0.1:8080;\n server 10.0.0.3:8080;\n server 10.0.0.2:8080 backup;\n keepalive 16;\n}\n"},
+		{"remote endpoints are primary without local ones", map[string]string{"10.0.0.2": "n2", "10.0.0.3": "n3"}, "n1",
+			"\nupstream svc_ns_80_local {\n server 10.0.0.2:8080;\n server 10.0.0.3:8080;\n keepalive 16;\n}\n"},
+	}
+	for _, c := range cases {
+		var sb strings.Builder
+		if err := conf.Render(&sb, newUpstream(route, testEndpoints(c.nodes), c.node).directive(16)); err != nil {
+			t.Fatal(err)
+		}
+		if sb.String() != c.expected {
+			t.Errorf("%v: upstream\n%v\nexpected\n%v", c.name, sb.String(), c.expected)
+		}
+	}
+}
+
+func TestEndpointSliceChanged(t *testing.T) {
+	slice := testSlice("svc", "10.0.0.1")
+	resynced := slice.DeepCopy()
+	resynced.ResourceVersion = "2"
+	if endpointSliceChanged(slice, resynced) {
+		t.Errorf("slice of the same endpoints changed")
+	}
+	moved := testSlice("svc", "10.0.0.2")
+	if !endpointSliceChanged(slice, moved) {
+		t.Errorf("slice of other endpoints not changed")
+	}
+}
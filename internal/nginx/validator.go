@@ -0,0 +1,135 @@
+package nginx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"k8s.io/klog/v2"
+	"ngress/internal/utils"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	validationTimeout = 30 * time.Second
+	stagedKeys        = "test" // generation name of private keys of the validated config
+)
+
+// ConfigState is the result of the last generated config validation
+type ConfigState struct {
+	Valid     bool
+	Error     string // nginx -t output for rejected config
+	CheckTime time.Time
+}
+
+// Validator runs 'nginx -t' against a staged copy of the config and certificates
+type Validator struct {
+	binary   string
+	stageDir string
+	keys     *Keys // staged private keys are written as applied ones
+
+	mu    sync.Mutex
+	state ConfigState
+}
+
+func newValidator(binary string, stageDir string, keys *Keys) *Validator {
+	return &Validator{binary: binary, stageDir: stageDir, keys: keys, state: ConfigState{Valid: true}}
+}
+
+func (c *Validator) enabled() bool {
+	return len(c.binary) > 0
+}
+
+// certsDir returns staged certificates directory, config for validation must be rendered with it
+func (c *Validator) certsDir() string {
+	return filepath.Join(c.stageDir, "certs")
+}
+
+func (c *Validator) stage(config string, certs map[string][]byte) (string, error) {
+	err := os.MkdirAll(c.stageDir, 0700)
+	if err == nil {
+		err = utils.RemoveGlob(fmt.Sprintf("%v/*", c.stageDir))
+	}
+	if err == nil {
+		err = c.keys.remove(stagedKeys)
+	}
+	if err != nil {
+		return "", err
+	}
+	for path, cert := range certs {
+		err = os.MkdirAll(filepath.Dir(path), 0700)
+		if err != nil {
+			return "", err
+		}
+		if isPrivateKey(path) {
+			rel, _ := filepath.Rel(c.certsDir(), path)
+			_, err = c.keys.write(stagedKeys, rel, path, cert)
+		} else {
+			err = os.WriteFile(path, cert, 0600)
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+
+	confPath := filepath.Join(c.stageDir, "ngress.conf")
+	err = os.WriteFile(confPath, []byte(config), 0644)
+	if err != nil {
+		return "", err
+	}
+
+	// minimal main config: generated ngress.conf is included into the http block
+	mainConf := fmt.Sprintf(`pid %v;
+events {
+}
+http {
+ access_log off;
+ include %v;
+}
+`, filepath.Join(c.stageDir, "nginx.pid"), confPath)
+	mainConfPath := filepath.Join(c.stageDir, "nginx.conf")
+	return mainConfPath, os.WriteFile(mainConfPath, []byte(mainConf), 0644)
+}
+
+// validate tests the config and keeps the result as current ConfigState
+func (c *Validator) validate(config string, certs map[string][]byte) error {
+	err := c.test(config, certs)
+	c.setState(err)
+	return err
+}
+
+// test returns nil if nginx accepts the config, config must be rendered with certsDir()
+func (c *Validator) test(config string, certs map[string][]byte) error {
+	mainConfPath, err := c.stage(config, certs)
+	if err == nil {
+		ctx, cancel := context.WithTimeout(context.Background(), validationTimeout)
+		defer cancel()
+		cmd := exec.CommandContext(ctx, c.binary, "-t", "-q", "-e", "stderr", "-p", c.stageDir, "-c", mainConfPath)
+		var output []byte
+		output, err = cmd.CombinedOutput()
+		if err != nil {
+			err = errors.New(fmt.Sprintf("error: '%v' nginx -t: %v", err, strings.TrimSpace(string(output))))
+		}
+	}
+	return err
+}
+
+func (c *Validator) setState(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.state = ConfigState{Valid: err == nil, CheckTime: time.Now()}
+	if err != nil {
+		c.state.Error = err.Error()
+		klog.Errorf("nginx config rejected, last-known-good config kept: %v", err)
+	}
+}
+
+func (c *Validator) State() ConfigState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.state
+}
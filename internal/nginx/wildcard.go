@@ -0,0 +1,34 @@
+package nginx
+
+import (
+	"regexp"
+	"strings"
+)
+
+// isWildcard is true for the wildcard host '*.example.com'
+func isWildcard(host string) bool {
+	return strings.HasPrefix(host, "*.")
+}
+
+// hostMatches returns true if the host is the pattern or the pattern is a wildcard covering the host,
+// as the ingress spec says the wildcard covers a single DNS label: '*.foo.com' matches 'bar.foo.com',
+// but not 'baz.bar.foo.com' and not 'foo.com'
+func hostMatches(pattern string, host string) bool {
+	if pattern == host {
+		return true
+	}
+	if !isWildcard(pattern) || isWildcard(host) {
+		return false
+	}
+	label, rest, found := strings.Cut(host, ".")
+	return found && len(label) > 0 && rest == pattern[2:]
+}
+
+// serverName returns nginx server_name of the host, nginx '*.foo.com' matches any number of labels,
+// so the wildcard is rendered as a regex of a single label, nginx prefers exact names to regex ones
+func serverName(host string) string {
+	if !isWildcard(host) {
+		return host
+	}
+	return `~^[^.]+\.` + regexp.QuoteMeta(host[2:]) + `$`
+}
@@ -0,0 +1,61 @@
+package nginx
+
+import (
+	core "k8s.io/api/core/v1"
+	networking "k8s.io/api/networking/v1"
+	"ngress/internal/conf"
+	"strings"
+	"testing"
+)
+
+// testWildcardHosts builds hosts of an exact ingress without annotations and TLS and a wildcard ingress
+// with websocket, http3 and TLS, the ingresses are applied in order of the names
+func testWildcardHosts(t *testing.T, exactName string, wildcardName string) map[string]*Host {
+	secrets, _ := testSecrets(t, "", fallbackLastGood)
+	services := newServices([]*core.Service{testService("exact"), testService("wildcard")})
+	c := &Controller{ingresses: make(map[string]*Ingress), quarantined: make(map[string]*Quarantined),
+		secrets: secrets, services: services}
+
+	exact := testIngress(exactName, "app.example.com", "exact")
+	wildcard := testIngress(wildcardName, "*.example.com", "wildcard")
+	wildcard.Annotations = map[string]string{"ngress.proto/websocket": "true", "ngress.proto/http3": "true"}
+	wildcard.Spec.Rules[0].HTTP.Paths[0].Path = "/api"
+	wildcard.Spec.TLS = []networking.IngressTLS{{Hosts: []string{"*.example.com"}, SecretName: "wildcard-tls"}}
+	for _, ingress := range []*networking.Ingress{exact, wildcard} {
+		c.ingresses[ingressName(ingress)] = newIngress(ingress, secrets, services, "node")
+	}
+	return c.buildHosts(c.contributing())
+}
+
+func TestWildcardMerge(t *testing.T) {
+	for _, names := range [][2]string{{"a-exact", "b-wildcard"}, {"b-exact", "a-wildcard"}} {
+		hosts := testWildcardHosts(t, names[0], names[1])
+		host := hosts["app.example.com"]
+		if len(host.tls) != 0 {
+			t.Errorf("%v: TLS secrets of the wildcard attached to the exact host: %v", names, host.tls[0].secretName)
+		}
+		if host.annotations.proto.http3 || host.annotations.proto.websocket {
+			t.Errorf("%v: annotations of the wildcard applied to the exact host: %v", names, host.annotations.string())
+		}
+		if wildcard := hosts["*.example.com"]; len(wildcard.tls) != 1 || wildcard.tls[0].secretName != "ns/wildcard-tls" {
+			t.Errorf("%v: TLS secrets of the wildcard host %v", names, wildcard.tls)
+		}
+
+		var sb strings.Builder
+		if err := conf.Render(&sb, host.buildServers(&RenderOpts{})...); err != nil {
+			t.Fatal(err)
+		}
+		config := sb.String()
+		root := config[strings.Index(config, `location "/" {`):]
+		api := config[strings.Index(config, `location "/api/" {`):]
+		if !strings.Contains(api[:strings.Index(api, "}")], "$http_upgrade") {
+			t.Errorf("%v: merged route lost websocket of its ingress in\n%v", names, config)
+		}
+		if strings.Contains(root[:strings.Index(root, "}")], "$http_upgrade") {
+			t.Errorf("%v: own route got websocket of the wildcard in\n%v", names, config)
+		}
+		if strings.Contains(config, "ssl") || strings.Contains(config, "quic") {
+			t.Errorf("%v: exact host is secure in\n%v", names, config)
+		}
+	}
+}
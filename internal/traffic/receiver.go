@@ -0,0 +1,176 @@
+package traffic
+
+import (
+	"errors"
+	"fmt"
+	"k8s.io/klog/v2"
+	"net"
+	"ngress/internal/conf"
+	"ngress/internal/metrics"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	logFormatName  = "ngress_traffic"
+	syslogTag      = "ngress"
+	maxMessageSize = 64 * 1024
+	fieldsCount    = 6
+)
+
+// Receiver aggregates nginx access log lines received over syslog into prometheus metrics.
+// Locations set $ngress_ingress (namespace/name), $ngress_host (ingress host) and $ngress_path (ingress path) variables.
+type Receiver struct {
+	address  string
+	conn     net.PacketConn
+	wg       sync.WaitGroup
+	requests *metrics.CounterVec
+	duration *metrics.HistogramVec
+	upstream *metrics.HistogramVec
+}
+
+// NewReceiver listens syslog on address in nginx syslog format: 'host:port' for UDP or 'unix:/path' for unix socket
+func NewReceiver(address string, registry *metrics.Registry) (*Receiver, error) {
+	c := &Receiver{
+		address: address,
+		requests: registry.NewCounterVec("ngress_http_requests_total",
+			"HTTP requests by ingress route and status class.", "namespace", "ingress", "host", "path", "status"),
+		duration: registry.NewHistogramVec("ngress_http_request_duration_seconds",
+			"HTTP request processing time by ingress route.", metrics.DefaultBuckets,
+			"namespace", "ingress", "host", "path"),
+		upstream: registry.NewHistogramVec("ngress_http_upstream_response_seconds",
+			"Upstream response time by ingress route.", metrics.DefaultBuckets,
+			"namespace", "ingress", "host", "path"),
+	}
+
+	var err error
+	if path, ok := strings.CutPrefix(address, "unix:"); ok {
+		_ = os.Remove(path)
+		c.conn, err = net.ListenPacket("unixgram", path)
+		if err == nil {
+			err = os.Chmod(path, 0666) // nginx workers are not root
+		}
+	} else {
+		c.conn, err = net.ListenPacket("udp", address)
+	}
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("error: '%v' listen syslog on: %v", err, address))
+	}
+
+	c.wg.Add(1)
+	go c.run()
+	klog.Infof("traffic syslog receiver listening on: %v", address)
+	return c, nil
+}
+
+// NginxConfig returns http level directives sending access log to the receiver.
+// access_log on the http level is added to the inherited logs, they stay untouched.
+// Variables are declared by map (locations override them by set), so config is valid without routes,
+// requests outside ingress locations are not sent.
+func (c *Receiver) NginxConfig() []*conf.Directive {
+	return []*conf.Directive{
+		conf.Block("map", "$host", "$ngress_ingress").Add(conf.New("default").Literal("")),
+		conf.Block("map", "$host", "$ngress_host").Add(conf.New("default").Literal("")),
+		conf.Block("map", "$host", "$ngress_path").Add(conf.New("default").Literal("")),
+		conf.New("log_format", logFormatName).
+			Literal("$ngress_ingress\t$ngress_host\t$ngress_path\t$status\t$request_time\t$upstream_response_time"),
+		conf.New("access_log", fmt.Sprintf("syslog:server=%v,tag=%v,nohostname", c.address, syslogTag),
+			logFormatName, "if=$ngress_ingress"),
+	}
+}
+
+// Route is the label values of a rendered ingress route
+type Route struct {
+	Namespace string
+	Ingress   string
+	Host      string
+	Path      string
+}
+
+// Retain deletes series of the routes not rendered anymore, metric cardinality follows the config
+func (c *Receiver) Retain(routes map[Route]struct{}) {
+	removed := func(labelValues []string) bool {
+		_, ok := routes[Route{Namespace: labelValues[0], Ingress: labelValues[1], Host: labelValues[2], Path: labelValues[3]}]
+		return !ok
+	}
+	c.requests.DeleteFunc(removed)
+	c.duration.DeleteFunc(removed)
+	c.upstream.DeleteFunc(removed)
+}
+
+func (c *Receiver) Stop() {
+	_ = c.conn.Close()
+	c.wg.Wait()
+	if path, ok := strings.CutPrefix(c.address, "unix:"); ok {
+		_ = os.Remove(path)
+	}
+}
+
+func (c *Receiver) run() {
+	defer c.wg.Done()
+	buf := make([]byte, maxMessageSize)
+	for {
+		n, _, err := c.conn.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			klog.Errorf("error: %v, reading syslog", err)
+			continue
+		}
+		c.handle(string(buf[:n]))
+	}
+}
+
+// handle parses '<PRI>Mmm dd hh:mm:ss ngress: fields' message
+func (c *Receiver) handle(message string) {
+	_, line, ok := strings.Cut(message, syslogTag+": ")
+	if !ok {
+		return
+	}
+	fields := strings.Split(strings.TrimRight(line, "\n"), "\t")
+	if len(fields) != fieldsCount {
+		klog.V(2).Infof("skip incorrect traffic log line: %v", line)
+		return
+	}
+
+	ingress, host, path, status := fields[0], fields[1], fields[2], fields[3]
+	namespace, name, ok := strings.Cut(ingress, "/")
+	if !ok {
+		return // not an ingress location
+	}
+
+	c.requests.Inc(namespace, name, host, path, statusClass(status))
+	requestTime, err := strconv.ParseFloat(fields[4], 64)
+	if err == nil {
+		c.duration.Observe(requestTime, namespace, name, host, path)
+	}
+	upstreamTime, ok := upstreamResponseTime(fields[5])
+	if ok {
+		c.upstream.Observe(upstreamTime, namespace, name, host, path)
+	}
+}
+
+func statusClass(status string) string {
+	if len(status) != 3 {
+		return "unknown"
+	}
+	return status[:1] + "xx"
+}
+
+// upstreamResponseTime sums times of all tried upstreams: '0.010, 0.020 : 0.001'
+func upstreamResponseTime(value string) (float64, bool) {
+	sum := 0.0
+	found := false
+	for _, part := range strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == ':' || r == ' ' }) {
+		v, err := strconv.ParseFloat(part, 64)
+		if err != nil {
+			continue
+		}
+		sum += v
+		found = true
+	}
+	return sum, found
+}
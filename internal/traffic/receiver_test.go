@@ -0,0 +1,111 @@
+package traffic
+
+import (
+	"net/http/httptest"
+	"ngress/internal/metrics"
+	"strings"
+	"testing"
+)
+
+// testReceiver returns the receiver without listener and the registry of its metrics
+func testReceiver() (*Receiver, *metrics.Registry) {
+	registry := metrics.NewRegistry()
+	c := &Receiver{
+		requests: registry.NewCounterVec("requests", "", "namespace", "ingress", "host", "path", "status"),
+		duration: registry.NewHistogramVec("duration", "", []float64{0.1}, "namespace", "ingress", "host", "path"),
+		upstream: registry.NewHistogramVec("upstream", "", []float64{0.1}, "namespace", "ingress", "host", "path"),
+	}
+	return c, registry
+}
+
+// testSeries returns sample lines of the metrics
+func testSeries(registry *metrics.Registry) string {
+	recorder := httptest.NewRecorder()
+	registry.ServeHTTP(recorder, nil)
+	var lines []string
+	for _, line := range strings.Split(recorder.Body.String(), "\n") {
+		if len(line) > 0 && !strings.HasPrefix(line, "#") && !strings.Contains(line, "_bucket") {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func TestUpstreamResponseTime(t *testing.T) {
+	cases := []struct {
+		value string
+		sum   float64
+		ok    bool
+	}{
+		{"0.010", 0.010, true},
+		{"0.010, 0.020", 0.030, true},
+		{"0.010, 0.020 : 0.001", 0.031, true},
+		{"0.010 : 0.020", 0.030, true},
+		{"0.005, -", 0.005, true},
+		{"-", 0, false},
+		{"-, - : -", 0, false},
+		{"", 0, false},
+		{"abc", 0, false},
+	}
+	for _, c := range cases {
+		sum, ok := upstreamResponseTime(c.value)
+		if ok != c.ok || (sum-c.sum) > 1e-9 || (c.sum-sum) > 1e-9 {
+			t.Errorf("%q: %v %v, expected %v %v", c.value, sum, ok, c.sum, c.ok)
+		}
+	}
+}
+
+func TestHandle(t *testing.T) {
+	cases := []struct {
+		name    string
+		message string
+		series  string
+	}{
+		{"request", "<190>Oct 18 10:00:00 ngress: ns/ing\ta.example.com\t/foo\t200\t0.012\t0.010, 0.002\n",
+			`requests{namespace="ns",ingress="ing",host="a.example.com",path="/foo",status="2xx"} 1
+duration_sum{namespace="ns",ingress="ing",host="a.example.com",path="/foo"} 0.012
+duration_count{namespace="ns",ingress="ing",host="a.example.com",path="/foo"} 1
+upstream_sum{namespace="ns",ingress="ing",host="a.example.com",path="/foo"} 0.012
+upstream_count{namespace="ns",ingress="ing",host="a.example.com",path="/foo"} 1`},
+		{"no upstream", "<190>Oct 18 10:00:00 ngress: ns/ing\t_\t/\t444\t0.000\t-",
+			`requests{namespace="ns",ingress="ing",host="_",path="/",status="4xx"} 1
+duration_sum{namespace="ns",ingress="ing",host="_",path="/"} 0
+duration_count{namespace="ns",ingress="ing",host="_",path="/"} 1`},
+		{"unknown status", "<190>Oct 18 10:00:00 ngress: ns/ing\ta\t/\t-\t-\t-",
+			`requests{namespace="ns",ingress="ing",host="a",path="/",status="unknown"} 1`},
+		{"label escaping", "<190>Oct 18 10:00:00 ngress: ns/ing\ta\t/\"q\\\t200\t0.1\t-",
+			`requests{namespace="ns",ingress="ing",host="a",path="/\"q\\",status="2xx"} 1
+duration_sum{namespace="ns",ingress="ing",host="a",path="/\"q\\"} 0.1
+duration_count{namespace="ns",ingress="ing",host="a",path="/\"q\\"} 1`},
+		{"not an ingress location", "<190>Oct 18 10:00:00 ngress: \ta\t/\t200\t0.1\t-", ""},
+		{"fields missing", "<190>Oct 18 10:00:00 ngress: ns/ing\ta\t/\t200\t0.1", ""},
+		{"fields extra", "<190>Oct 18 10:00:00 ngress: ns/ing\ta\t/\t200\t0.1\t-\tx", ""},
+		{"other tag", "<190>Oct 18 10:00:00 nginx: ns/ing\ta\t/\t200\t0.1\t-", ""},
+		{"empty", "", ""},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			c, registry := testReceiver()
+			c.handle(tc.message)
+			if series := testSeries(registry); series != tc.series {
+				t.Errorf("series:\n%v\nexpected:\n%v", series, tc.series)
+			}
+		})
+	}
+}
+
+func TestRetain(t *testing.T) {
+	c, registry := testReceiver()
+	c.handle("<190>Oct 18 10:00:00 ngress: ns/a\ta.example.com\t/\t200\t0.1\t0.1")
+	c.handle("<190>Oct 18 10:00:00 ngress: ns/a\ta.example.com\t/\t500\t0.1\t0.1")
+	c.handle("<190>Oct 18 10:00:00 ngress: ns/b\tb.example.com\t/\t200\t0.1\t0.1")
+
+	c.Retain(map[Route]struct{}{{Namespace: "ns", Ingress: "b", Host: "b.example.com", Path: "/"}: {}})
+	series := testSeries(registry)
+	if strings.Contains(series, `ingress="a"`) {
+		t.Errorf("series of the removed route kept:\n%v", series)
+	}
+	if strings.Count(series, `ingress="b"`) != 5 {
+		t.Errorf("series of the rendered route removed:\n%v", series)
+	}
+}